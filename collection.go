@@ -3,10 +3,14 @@ package migrations
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"math"
+	"log/slog"
 	"net/http"
 	"os"
 	"path"
@@ -17,31 +21,76 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-pg/pg/v10"
 )
 
 type Migration struct {
-	Version int64
+	Version     int64
+	Name        string
+	Description string
 
 	UpTx bool
 	Up   func(DB) error
 
 	DownTx bool
 	Down   func(DB) error
+
+	// Irreversible migrations can't be reverted: down commands
+	// stop with ErrIrreversible when they reach the migration.
+	// It is set by the --gopg:irreversible directive of SQL migrations.
+	Irreversible bool
+
+	// Retry overrides the retry policy of the collection. It is set
+	// by the --gopg:retry directive of SQL migrations.
+	Retry *RetryPolicy
+
+	// Files the migration was loaded from.
+	upFile   string
+	downFile string
+	// sqlFS is the filesystem of SQL migration files.
+	sqlFS http.FileSystem
+	// Isolation levels set by --gopg:isolation directives.
+	upIsolation   string
+	downIsolation string
+
+	// Checksums of SQL migration files.
+	upChecksum   string
+	downChecksum string
+	// Errors of statements that can't run in a transaction block and
+	// must be split from other statements. See checkNonTxStatements.
+	upSplitErr   error
+	downSplitErr error
 }
 
 func (m *Migration) String() string {
-	return strconv.FormatInt(m.Version, 10)
+	if m.Name == "" {
+		return strconv.FormatInt(m.Version, 10)
+	}
+	return strconv.FormatInt(m.Version, 10) + " " + m.Name
 }
 
 type Collection struct {
 	tableName               string
 	sqlAutodiscoverDisabled bool
-
-	mu          sync.Mutex
-	visitedDirs map[string]struct{}
-	migrations  []*Migration // sorted
+	checksumsRequired       bool
+	downRequired            bool
+	outOfOrder              bool
+	locker                  Locker
+	sqlStatementsSplit      bool
+	logger                  *slog.Logger
+	retry                   *RetryPolicy
+	migrationRetries        map[int64]*RetryPolicy
+	irreversible            map[int64]bool
+	preflight               *Preflight
+	observers               []Observer
+
+	mu            sync.Mutex
+	visitedDirs   map[string]error // discovery errors by dir
+	visitedFiles  map[fsFile]struct{}
+	migrations    []*Migration // sorted
+	tableUpgraded bool
 }
 
 func NewCollection(migrations ...*Migration) *Collection {
@@ -71,6 +120,95 @@ func (c *Collection) DisableSQLAutodiscover(flag bool) *Collection {
 	return c
 }
 
+// RequireChecksums makes the up command fail when an applied SQL migration
+// was changed after it had been applied. See Verify.
+func (c *Collection) RequireChecksums(flag bool) *Collection {
+	c.checksumsRequired = flag
+	return c
+}
+
+// RequireDown makes down commands treat migrations without Down func
+// as irreversible instead of reverting them without running anything.
+func (c *Collection) RequireDown(flag bool) *Collection {
+	c.downRequired = flag
+	return c
+}
+
+// AllowOutOfOrder makes the up command apply missing migrations
+// with versions lower than the current version, e.g. after merging
+// a long-lived branch. Applied migrations are tracked using the history
+// in the migrations table instead of the current version.
+func (c *Collection) AllowOutOfOrder(flag bool) *Collection {
+	c.outOfOrder = flag
+	return c
+}
+
+// SetLocker sets the Locker that is held for the whole run. By default
+// the migrations table is locked in a transaction that is committed
+// after each migration.
+func (c *Collection) SetLocker(locker Locker) *Collection {
+	c.locker = locker
+	return c
+}
+
+// SetLogger sets the logger that logs executed and failed migrations
+// and lock waits.
+func (c *Collection) SetLogger(logger *slog.Logger) *Collection {
+	c.logger = logger
+	return c
+}
+
+// SetRetryPolicy sets the policy used to retry transactional migrations
+// that fail to acquire locks. Migration.Retry overrides it.
+func (c *Collection) SetRetryPolicy(policy *RetryPolicy) *Collection {
+	c.retry = policy
+	return c
+}
+
+// SetIrreversible marks the migration with the version irreversible,
+// e.g. the one registered with Register or RegisterVersion.
+// See Migration.Irreversible.
+func (c *Collection) SetIrreversible(version int64) *Collection {
+	if c.irreversible == nil {
+		c.irreversible = make(map[int64]bool)
+	}
+	c.irreversible[version] = true
+	return c
+}
+
+// SetMigrationRetryPolicy sets the retry policy of the migration with
+// the version, e.g. the one registered with Register or RegisterVersion.
+// It overrides Migration.Retry and the policy of the collection.
+func (c *Collection) SetMigrationRetryPolicy(version int64, policy *RetryPolicy) *Collection {
+	if c.migrationRetries == nil {
+		c.migrationRetries = make(map[int64]*RetryPolicy)
+	}
+	c.migrationRetries[version] = policy
+	return c
+}
+
+// SetPreflight sets the check of sessions that may block migrations.
+// It is run before commands that run migrations.
+func (c *Collection) SetPreflight(preflight *Preflight) *Collection {
+	c.preflight = preflight
+	return c
+}
+
+// AddObserver adds the observer notified about migration lifecycle events.
+func (c *Collection) AddObserver(observer Observer) *Collection {
+	c.observers = append(c.observers, observer)
+	return c
+}
+
+// SplitSQLStatements makes SQL migrations split into statements on semicolons
+// that are not inside strings or comments. Statements are executed one at a time
+// and errors report the failing statement. It can be called after migrations
+// are discovered.
+func (c *Collection) SplitSQLStatements(flag bool) *Collection {
+	c.sqlStatementsSplit = flag
+	return c
+}
+
 // Register registers new database migration. Must be called
 // from a file with name like "1_initialize_db.go".
 func (c *Collection) Register(fns ...func(DB) error) error {
@@ -97,7 +235,7 @@ func (c *Collection) register(tx bool, fns ...func(DB) error) error {
 	}
 
 	file := migrationFile()
-	version, err := extractVersionGo(file)
+	version, name, err := extractVersionGo(file)
 	if err != nil {
 		return err
 	}
@@ -111,12 +249,16 @@ func (c *Collection) register(tx bool, fns ...func(DB) error) error {
 
 	c.addMigration(&Migration{
 		Version: version,
+		Name:    name,
 
 		UpTx: tx,
 		Up:   up,
 
 		DownTx: tx,
 		Down:   down,
+
+		upFile:   file,
+		downFile: file,
 	})
 
 	return nil
@@ -155,10 +297,24 @@ func (c *Collection) DiscoverSQLMigrations(dir string) error {
 // DiscoverSQLMigrations scan the dir from the given filesystem for files with .sql extension
 // and adds discovered SQL migrations to the collection.
 func (c *Collection) DiscoverSQLMigrationsFromFilesystem(fs http.FileSystem, dir string) error {
-	if c.isVisitedDir(dir) {
-		return nil
+	return c.discoverDir(fs, dir, false)
+}
+
+// discoverDir discovers SQL migrations in the dir once. Autodiscovery
+// skips files that don't look like migrations, e.g. schema.sql.
+func (c *Collection) discoverDir(fs http.FileSystem, dir string, autodiscover bool) error {
+	if visited, err := c.visitDir(dir); visited {
+		return err
 	}
 
+	err := c.discoverSQLMigrations(fs, dir, autodiscover)
+	if err != nil {
+		c.setDirError(dir, err)
+	}
+	return err
+}
+
+func (c *Collection) discoverSQLMigrations(fs http.FileSystem, dir string, autodiscover bool) error {
 	f, err := fs.Open(dir)
 	if os.IsNotExist(err) {
 		return nil
@@ -172,6 +328,39 @@ func (c *Collection) DiscoverSQLMigrationsFromFilesystem(fs http.FileSystem, dir
 		return nil
 	}
 
+	files, err := f.Readdir(-1)
+	if err != nil {
+		return err
+	}
+
+	// Sort files to have consistent errors.
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+	var paths []string
+	for _, f := range files {
+		if f.IsDir() || (autodiscover && !isMigrationFileName(f.Name())) {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, f.Name()))
+	}
+
+	return c.addSQLMigrations(fs, paths)
+}
+
+// isMigrationFileName reports whether the file name looks like an SQL
+// migration, i.e. starts with a version, e.g. 1_initial.up.sql.
+func isMigrationFileName(name string) bool {
+	idx := strings.IndexByte(name, '_')
+	if idx <= 0 || !strings.HasSuffix(name, ".sql") {
+		return false
+	}
+	_, err := strconv.ParseInt(name[:idx], 10, 64)
+	return err == nil
+}
+
+// addSQLMigrations adds migrations from the SQL files. Files with
+// other extensions are ignored.
+func (c *Collection) addSQLMigrations(fs http.FileSystem, paths []string) error {
 	var ms []*Migration
 	newMigration := func(version int64) *Migration {
 		for i := range ms {
@@ -187,20 +376,8 @@ func (c *Collection) DiscoverSQLMigrationsFromFilesystem(fs http.FileSystem, dir
 		return ms[len(ms)-1]
 	}
 
-	files, err := f.Readdir(-1)
-	if err != nil {
-		return err
-	}
-
-	// Sort files to have consistent errors.
-	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
-
-	for _, f := range files {
-		if f.IsDir() {
-			continue
-		}
-
-		fileName := f.Name()
+	for _, filePath := range paths {
+		fileName := filepath.Base(filePath)
 		if !strings.HasSuffix(fileName, ".sql") {
 			continue
 		}
@@ -209,38 +386,99 @@ func (c *Collection) DiscoverSQLMigrationsFromFilesystem(fs http.FileSystem, dir
 		if idx == -1 {
 			err := fmt.Errorf(
 				"file=%q must have name in format version_comment, e.g. 1_initial",
-				fileName)
+				filePath)
 			return err
 		}
 
 		version, err := strconv.ParseInt(fileName[:idx], 10, 64)
 		if err != nil {
-			return err
+			return fmt.Errorf("file=%q: %w", filePath, err)
 		}
 
 		m := newMigration(version)
-		filePath := filepath.Join(dir, fileName)
+		// Up file names take precedence.
+		name := sqlMigrationName(fileName[idx+1:])
+		if m.Name == "" || strings.HasSuffix(fileName, ".up.sql") {
+			m.Name = name
+		}
 
 		if strings.HasSuffix(fileName, ".up.sql") {
 			if m.Up != nil {
-				return fmt.Errorf("migration=%d already has Up func", version)
+				return fmt.Errorf("file=%q: migration=%d already has Up func", filePath, version)
+			}
+			b, d, err := readSQLFile(fs, filePath)
+			if err != nil {
+				return err
+			}
+			m.upChecksum = checksum(b)
+			m.UpTx, m.upSplitErr, err = sqlMigrationTx(b, d, strings.HasSuffix(fileName, ".tx.up.sql"))
+			if err != nil {
+				return fmt.Errorf("file=%q: %w", filePath, err)
+			}
+			m.Up = c.newSQLMigration(fs, filePath, version, "up", d)
+			m.upFile = filePath
+			m.upIsolation = d.isolation
+			m.Irreversible = d.irreversible
+			if d.retry > 0 {
+				if !m.UpTx {
+					return fmt.Errorf(
+						"file=%q: gopg directive retry requires a transaction", filePath)
+				}
+				m.Retry = d.retryPolicy()
+			}
+			m.sqlFS = fs
+			if d.description != "" {
+				m.Description = d.description
 			}
-			m.UpTx = strings.HasSuffix(fileName, ".tx.up.sql")
-			m.Up = newSQLMigration(fs, filePath)
 			continue
 		}
 
 		if strings.HasSuffix(fileName, ".down.sql") {
 			if m.Down != nil {
-				return fmt.Errorf("migration=%d already has Down func", version)
+				return fmt.Errorf("file=%q: migration=%d already has Down func", filePath, version)
+			}
+			b, d, err := readSQLFile(fs, filePath)
+			if err != nil {
+				return err
+			}
+			if d.irreversible {
+				return fmt.Errorf(
+					"file=%q: gopg directive irreversible must be in the up file", filePath)
+			}
+			m.downChecksum = checksum(b)
+			m.DownTx, m.downSplitErr, err = sqlMigrationTx(b, d, strings.HasSuffix(fileName, ".tx.down.sql"))
+			if err != nil {
+				return fmt.Errorf("file=%q: %w", filePath, err)
+			}
+			m.Down = c.newSQLMigration(fs, filePath, version, "down", d)
+			m.downFile = filePath
+			m.downIsolation = d.isolation
+			m.sqlFS = fs
+			if d.retry > 0 {
+				if !m.DownTx {
+					return fmt.Errorf(
+						"file=%q: gopg directive retry requires a transaction", filePath)
+				}
+				// Up files take precedence.
+				if m.Retry == nil {
+					m.Retry = d.retryPolicy()
+				}
+			}
+			if m.Description == "" {
+				m.Description = d.description
 			}
-			m.DownTx = strings.HasSuffix(fileName, ".tx.down.sql")
-			m.Down = newSQLMigration(fs, filePath)
 			continue
 		}
 
 		return fmt.Errorf(
-			"file=%q must have extension .up.sql or .down.sql", fileName)
+			"file=%q must have extension .up.sql or .down.sql", filePath)
+	}
+
+	for _, m := range ms {
+		if m.Irreversible && m.downFile != "" {
+			return fmt.Errorf(
+				"file=%q: migration=%d is irreversible, but has down file", m.downFile, m.Version)
+		}
 	}
 
 	for _, m := range ms {
@@ -250,60 +488,84 @@ func (c *Collection) DiscoverSQLMigrationsFromFilesystem(fs http.FileSystem, dir
 	return nil
 }
 
-func (c *Collection) isVisitedDir(dir string) bool {
+// sqlMigrationName strips extensions like .tx.up.sql from the comment part
+// of the migration file name.
+func sqlMigrationName(s string) string {
+	for _, ext := range []string{".tx.up.sql", ".tx.down.sql", ".up.sql", ".down.sql", ".sql"} {
+		if strings.HasSuffix(s, ext) {
+			return strings.TrimSuffix(s, ext)
+		}
+	}
+	return s
+}
+
+// visitDir marks the dir as visited. For visited dirs it returns
+// the discovery error, so the error is reported every time.
+func (c *Collection) visitDir(dir string) (bool, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if _, ok := c.visitedDirs[dir]; ok {
-		return true
+	if err, ok := c.visitedDirs[dir]; ok {
+		return true, err
 	}
 
 	if c.visitedDirs == nil {
-		c.visitedDirs = make(map[string]struct{})
+		c.visitedDirs = make(map[string]error)
 	}
-	c.visitedDirs[dir] = struct{}{}
+	c.visitedDirs[dir] = nil
 
-	return false
+	return false, nil
 }
 
-func newSQLMigration(fs http.FileSystem, filePath string) func(DB) error {
-	return func(db DB) error {
-		f, err := fs.Open(filePath)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
+func (c *Collection) setDirError(dir string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-		scanner := bufio.NewScanner(f)
+	c.visitedDirs[dir] = err
+}
 
-		var query []byte
-		var queries []string
-		for scanner.Scan() {
-			b := scanner.Bytes()
+// readSQLFile reads the SQL migration file and parses its directives.
+func readSQLFile(fs http.FileSystem, filePath string) ([]byte, *sqlDirectives, error) {
+	f, err := fs.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
 
-			const prefix = "--gopg:"
-			if bytes.HasPrefix(b, []byte(prefix)) {
-				b = b[len(prefix):]
-				if bytes.Equal(b, []byte("split")) {
-					queries = append(queries, string(query))
-					query = query[:0]
-					continue
-				}
-				return fmt.Errorf("unknown gopg directive: %q", b)
-			}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
 
-			query = append(query, b...)
-			query = append(query, '\n')
-		}
-		if len(query) > 0 {
-			queries = append(queries, string(query))
-		}
+	d, err := parseSQLDirectives(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("file=%q: %w", filePath, err)
+	}
 
-		if err := scanner.Err(); err != nil {
-			return err
+	return b, d, nil
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Collection) newSQLMigration(
+	fs http.FileSystem, filePath string, version int64, direction string, d *sqlDirectives,
+) func(DB) error {
+	return func(db DB) error {
+		queries, err := c.readSQLMigration(fs, filePath)
+		if err != nil {
+			return &MigrationError{
+				Version:   version,
+				Direction: direction,
+				File:      filePath,
+				Err:       err,
+			}
 		}
 
-		if len(queries) > 1 {
+		settings := d.settings()
+		if len(queries) > 1 || len(settings) > 0 {
 			switch v := db.(type) {
 			case *pg.DB:
 				conn := v.Conn()
@@ -312,10 +574,26 @@ func newSQLMigration(fs http.FileSystem, filePath string) func(DB) error {
 			}
 		}
 
-		for _, q := range queries {
-			_, err = db.Exec(q)
+		if len(settings) > 0 {
+			reset, err := applySQLSettings(db, settings)
 			if err != nil {
-				return err
+				return &MigrationError{
+					Version:   version,
+					Direction: direction,
+					File:      filePath,
+					Err:       err,
+				}
+			}
+			defer reset()
+		}
+
+		for i, q := range queries {
+			c.onStatement(db.Context(), filePath, q.line, q.query)
+			_, err = db.Exec(q.query)
+			if err != nil {
+				merr := newMigrationError(version, direction, filePath, i+1, q, err)
+				merr.Err = txBlockError(err)
+				return merr
 			}
 		}
 
@@ -323,6 +601,69 @@ func newSQLMigration(fs http.FileSystem, filePath string) func(DB) error {
 	}
 }
 
+// readSQLMigration reads the SQL migration file and splits it into queries.
+func (c *Collection) readSQLMigration(fs http.FileSystem, filePath string) ([]sqlQuery, error) {
+	f, err := fs.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return c.splitSQLMigration(f)
+}
+
+// splitSQLMigration splits the SQL migration into queries
+// using --gopg:split directives and, if enabled, semicolons.
+func (c *Collection) splitSQLMigration(r io.Reader) ([]sqlQuery, error) {
+	return splitSQLQueries(r, c.sqlStatementsSplit)
+}
+
+// splitSQLQueries splits the SQL migration into queries using --gopg:split
+// directives and, if semicolons is true, on semicolons.
+func splitSQLQueries(r io.Reader, semicolons bool) ([]sqlQuery, error) {
+	scanner := bufio.NewScanner(r)
+
+	var query []byte
+	var queries []sqlQuery
+	line, queryLine := 0, 1
+	for scanner.Scan() {
+		b := scanner.Bytes()
+		line++
+
+		// Other directives are validated when migrations are discovered
+		// and are left in the query as comments.
+		if name, _, ok := cutDirective(string(bytes.TrimSpace(b))); ok && name == "split" {
+			queries = append(queries, sqlQuery{query: string(query), line: queryLine, column: 1})
+			query = query[:0]
+			queryLine = line + 1
+			continue
+		}
+
+		query = append(query, b...)
+		query = append(query, '\n')
+	}
+	if len(query) > 0 {
+		queries = append(queries, sqlQuery{query: string(query), line: queryLine, column: 1})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if !semicolons {
+		return queries, nil
+	}
+
+	var statements []sqlQuery
+	for _, q := range queries {
+		for _, st := range splitSQL(q.query) {
+			st.line += q.line - 1
+			statements = append(statements, st)
+		}
+	}
+	return statements, nil
+}
+
 func (c *Collection) addMigration(migration *Migration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -344,6 +685,43 @@ func insert(s []*Migration, i int, x *Migration) []*Migration {
 	return s
 }
 
+// RegisterVersion registers migration with the version and name.
+// Unlike Register it does not depend on the name of the caller file,
+// so migrations can be defined anywhere. down may be nil.
+func (c *Collection) RegisterVersion(version int64, name string, up, down func(DB) error) error {
+	return c.registerVersion(false, version, name, up, down)
+}
+
+// RegisterVersionTx is just like RegisterVersion but marks the migration
+// to be executed inside a transaction.
+func (c *Collection) RegisterVersionTx(version int64, name string, up, down func(DB) error) error {
+	return c.registerVersion(true, version, name, up, down)
+}
+
+func (c *Collection) registerVersion(
+	tx bool, version int64, name string, up, down func(DB) error,
+) error {
+	if version <= 0 {
+		return fmt.Errorf("migration=%d: version must be positive", version)
+	}
+	if up == nil {
+		return fmt.Errorf("migration=%d: up func is required", version)
+	}
+
+	c.addMigration(&Migration{
+		Version: version,
+		Name:    name,
+
+		UpTx: tx,
+		Up:   up,
+
+		DownTx: tx,
+		Down:   down,
+	})
+
+	return nil
+}
+
 func (c *Collection) MustRegister(fns ...func(DB) error) {
 	err := c.Register(fns...)
 	if err != nil {
@@ -358,13 +736,27 @@ func (c *Collection) MustRegisterTx(fns ...func(DB) error) {
 	}
 }
 
+// Migrations returns registered and discovered migrations. Errors of SQL
+// migrations autodiscovery are ignored, but returned by commands.
 func (c *Collection) Migrations() []*Migration {
+	migrations, _ := c.discoverMigrations()
+	return migrations
+}
+
+// discoverMigrations is like Migrations, but returns errors of SQL
+// migrations discovered in the dir of the caller. Errors in the working
+// dir, which may contain unrelated SQL files, are ignored.
+func (c *Collection) discoverMigrations() ([]*Migration, error) {
+	var discoverErr error
 	if !c.sqlAutodiscoverDisabled {
-		_ = c.DiscoverSQLMigrations(filepath.Dir(migrationFile()))
+		dir, err := filepath.Abs(filepath.Dir(migrationFile()))
+		if err == nil {
+			discoverErr = c.discoverDir(osfilesystem{}, dir, true)
+		}
 
-		dir, err := os.Getwd()
+		dir, err = os.Getwd()
 		if err == nil {
-			_ = c.DiscoverSQLMigrations(dir)
+			_ = c.discoverDir(osfilesystem{}, dir, true)
 		}
 	}
 
@@ -375,22 +767,66 @@ func (c *Collection) Migrations() []*Migration {
 	migrations := make([]*Migration, len(c.migrations))
 	copy(migrations, c.migrations)
 
-	return migrations
+	return migrations, discoverErr
 }
 
+// Run runs the command on the db using the db context. See RunContext.
 func (c *Collection) Run(db DB, a ...string) (oldVersion, newVersion int64, err error) {
-	migrations := c.Migrations()
+	return c.RunContext(db.Context(), db, a...)
+}
+
+// RunContext runs the command on the db. The context is used for all queries
+// including the ones made by migrations, and cancelling it aborts the run
+// between migrations.
+func (c *Collection) RunContext(
+	ctx context.Context, db DB, a ...string,
+) (oldVersion, newVersion int64, err error) {
+	res, err := c.Exec(ctx, db, ParseCommand(a...))
+	if werr := writeResult(os.Stdout, res); werr != nil && err == nil {
+		err = werr
+	}
+	return res.OldVersion, res.NewVersion, err
+}
+
+// Exec runs the command on the db like RunContext, but instead of printing
+// the output it returns the result. The result is returned even when
+// the command fails and contains the migrations run before the failure.
+func (c *Collection) Exec(ctx context.Context, db DB, cmd Command) (res *RunResult, err error) {
+	if cmd.Name == "" {
+		cmd.Name = "up"
+	}
+	res = &RunResult{Command: cmd}
+
+	start := time.Now()
+	var oldVersion, newVersion int64
+	defer func() {
+		res.OldVersion = oldVersion
+		res.NewVersion = newVersion
+		res.Duration = time.Since(start)
+		c.afterRun(ctx, res, err)
+	}()
+
+	if cmd.Options.Atomic && cmd.Name != "up" {
+		err = fmt.Errorf("%s does not support --atomic", cmd.Name)
+		return
+	}
+
+	db = withContext(ctx, db)
+
+	migrations, err := c.discoverMigrations()
+	if err != nil {
+		return
+	}
 	err = validateMigrations(migrations)
 	if err != nil {
 		return
 	}
-
-	cmd := "up"
-	if len(a) > 0 {
-		cmd = a[0]
+	err = c.checkNonTxStatements(migrations)
+	if err != nil {
+		return
 	}
 
-	switch cmd {
+	switch cmd.Name {
 	case "init":
 		err = c.createTable(db)
 		if err != nil {
@@ -398,8 +834,8 @@ func (c *Collection) Run(db DB, a ...string) (oldVersion, newVersion int64, err
 		}
 		return
 	case "create":
-		if len(a) < 2 {
-			fmt.Println("please provide migration description")
+		if len(cmd.Args) == 0 {
+			err = fmt.Errorf("create requires migration description, e.g. create add email to users")
 			return
 		}
 
@@ -408,13 +844,13 @@ func (c *Collection) Run(db DB, a ...string) (oldVersion, newVersion int64, err
 			version = migrations[len(migrations)-1].Version
 		}
 
-		filename := fmtMigrationFilename(version+1, strings.Join(a[1:], "_"))
+		filename := fmtMigrationFilename(version+1, strings.Join(cmd.Args, "_"))
 		err = createMigrationFile(filename)
 		if err != nil {
 			return
 		}
 
-		fmt.Println("created new migration", filename)
+		res.File = filename
 		return
 	}
 
@@ -427,102 +863,164 @@ func (c *Collection) Run(db DB, a ...string) (oldVersion, newVersion int64, err
 		return
 	}
 
-	tx, version, err := c.begin(db)
+	if c.locker != nil {
+		var unlock func() error
+		err = c.waitLock(ctx, fmt.Sprintf("%T", c.locker), func() error {
+			var err error
+			unlock, err = c.locker.Lock(ctx, db)
+			return err
+		})
+		if err != nil {
+			c.onError(ctx, nil, "", err)
+			return
+		}
+		defer func() {
+			if unlockErr := unlock(); unlockErr != nil && err == nil {
+				err = unlockErr
+			}
+		}()
+	}
+
+	err = c.upgradeTable(db)
+	if err != nil {
+		return
+	}
+
+	tx, version, err := c.begin(ctx, db, "")
 	if err != nil {
 		return
 	}
-	defer tx.Close() //nolint
+	defer func() {
+		// The context may be cancelled, but the transaction still must be rolled back.
+		if tx != nil {
+			_ = tx.CloseContext(context.Background())
+		}
+	}()
 
 	oldVersion = version
 	newVersion = version
 
-	switch cmd {
-	case "version":
-	case "up":
-		target := int64(math.MaxInt64)
-		if len(a) > 1 {
-			target, err = strconv.ParseInt(a[1], 10, 64)
+	dirty, err := c.dirtyMigration(tx)
+	if err != nil {
+		return
+	}
+	if dirty != nil {
+		switch cmd.Name {
+		case "up", "down", "down-to", "reset", "goto", "redo":
+			err = dirty.err()
+			return
+		case "force":
+		default:
+			res.addWarning("%s", dirty.err())
+		}
+	}
+
+	if c.preflight != nil {
+		switch cmd.Name {
+		case "up", "down", "down-to", "reset", "goto", "redo":
+			err = c.checkBlockingSessions(tx, res)
 			if err != nil {
+				c.onError(ctx, nil, "", err)
 				return
 			}
-			if version > target {
-				break
-			}
 		}
+	}
 
-		for _, m := range migrations {
-			if m.Version > target {
-				break
-			}
+	switch cmd.Name {
+	case "version":
+	case "pending":
+		res.Pending, err = c.pending(tx, migrations, version)
+		if err != nil {
+			return
+		}
+	case "status":
+		res.Status, err = c.status(tx, migrations, version)
+		if err != nil {
+			return
+		}
+	case "plan":
+		res.Plan, err = c.plan(tx, migrations, version, cmd.Args...)
+		if err != nil {
+			return
+		}
+	case "verify":
+		err = c.verifyChecksums(tx, migrations)
+		if err != nil {
+			return
+		}
+	case "up", "down", "down-to", "reset", "goto":
+		var st *steps
+		st, err = parseSteps(cmd, version)
+		if err != nil {
+			return
+		}
 
-			if tx == nil {
-				tx, version, err = c.begin(db)
-				if err != nil {
-					return
-				}
+		if st.direction == "down" {
+			tx, newVersion, err = c.downTo(ctx, db, tx, res, migrations, version, st.target, st.n)
+			if err != nil {
+				return
 			}
-
-			if m.Version <= version {
-				continue
+			if newVersion == version && cmd.Name != "reset" {
+				res.addWarning("there are no migrations to revert")
 			}
+			break
+		}
 
-			newVersion, err = c.runUp(db, tx, m)
+		if c.checksumsRequired {
+			err = c.verifyChecksums(tx, migrations)
 			if err != nil {
 				return
 			}
+		}
+		if version > st.target && !c.outOfOrder {
+			res.addWarning("version=%d is higher than target=%d", version, st.target)
+			break
+		}
 
-			err = tx.Commit()
+		if cmd.Options.Atomic {
+			newVersion, err = c.upAtomic(ctx, db, tx, res, migrations, version, st.target, st.n)
 			if err != nil {
 				return
 			}
-			tx = nil
+			break
 		}
-	case "down":
-		newVersion, err = c.down(db, tx, migrations, version)
+		tx, newVersion, err = c.up(ctx, db, tx, res, migrations, version, st.target, st.n)
 		if err != nil {
 			return
 		}
-	case "reset":
-		for {
-			if tx == nil {
-				tx, version, err = c.begin(db)
-				if err != nil {
-					return
-				}
-			}
-
-			newVersion, err = c.down(db, tx, migrations, version)
-			if err != nil {
-				return
-			}
-
-			err = tx.Commit()
+	case "redo":
+		n := 1
+		if len(cmd.Args) > 0 {
+			n, err = parseCount(cmd.Args[0])
 			if err != nil {
 				return
 			}
-			tx = nil
+		}
 
-			if newVersion == version {
-				break
-			}
-			version = newVersion
+		tx, newVersion, err = c.redo(ctx, db, tx, res, migrations, version, n)
+		if err != nil {
+			return
 		}
-	case "set_version":
-		if len(a) < 2 {
-			err = fmt.Errorf("set_version requires version as 2nd arg, e.g. set_version 42")
+	case "set_version", "force":
+		if len(cmd.Args) == 0 {
+			err = fmt.Errorf(
+				"%s requires version as 2nd arg, e.g. %s 42", cmd.Name, cmd.Name)
 			return
 		}
 
-		newVersion, err = strconv.ParseInt(a[1], 10, 64)
+		newVersion, err = strconv.ParseInt(cmd.Args[0], 10, 64)
 		if err != nil {
 			return
 		}
+		if cmd.Name == "force" && dirty == nil {
+			res.addWarning("database is not dirty")
+		}
 		err = c.SetVersion(tx, newVersion)
 		if err != nil {
 			return
 		}
 	default:
-		err = fmt.Errorf("unsupported command: %q", cmd)
+		err = fmt.Errorf("unsupported command: %q", cmd.Name)
 		if err != nil {
 			return
 		}
@@ -531,6 +1029,17 @@ func (c *Collection) Run(db DB, a ...string) (oldVersion, newVersion int64, err
 	if tx != nil {
 		err = tx.Commit()
 	}
+	if c.singleTx(cmd) {
+		if err != nil {
+			// The migrations are rolled back with the transaction.
+			res.Migrations = nil
+			newVersion = oldVersion
+			return
+		}
+		for _, m := range res.Migrations {
+			c.afterMigration(ctx, m)
+		}
+	}
 	return
 }
 
@@ -546,26 +1055,330 @@ func validateMigrations(migrations []*Migration) error {
 	return nil
 }
 
-func (c *Collection) runUp(db DB, tx *pg.Tx, m *Migration) (int64, error) {
-	if m.UpTx {
-		db = tx
+// up applies migrations with versions up to the target committing after
+// each migration. n limits the number of migrations unless it is zero.
+func (c *Collection) up(
+	ctx context.Context, db DB, tx *pg.Tx, res *RunResult,
+	migrations []*Migration, version, target int64, n int,
+) (_ *pg.Tx, newVersion int64, err error) {
+	newVersion = version
+
+	var applied map[int64]*appliedMigration
+	var count int
+	for _, m := range migrations {
+		if m.Version > target || (n > 0 && count == n) {
+			break
+		}
+
+		if err = ctx.Err(); err != nil {
+			return tx, newVersion, err
+		}
+
+		if tx == nil || m.upIsolation != "" {
+			// Isolation level must be set before any query,
+			// so the transaction is restarted.
+			if tx != nil {
+				_ = tx.Rollback()
+			}
+			tx, version, err = c.begin(ctx, db, m.upIsolation)
+			if err != nil {
+				return nil, newVersion, err
+			}
+			applied = nil
+		}
+
+		if c.outOfOrder {
+			if applied == nil {
+				applied, err = c.appliedVersions(tx, migrations)
+				if err != nil {
+					return tx, newVersion, err
+				}
+			}
+			if applied[m.Version] != nil {
+				continue
+			}
+		} else if m.Version <= version {
+			continue
+		}
+
+		tx, newVersion, err = c.runUp(ctx, db, tx, res, m, version)
+		if err != nil {
+			return tx, newVersion, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return tx, version, err
+		}
+		tx = nil
+		count++
+	}
+
+	return tx, newVersion, nil
+}
+
+// upAtomic applies migrations like up, but in the single transaction tx.
+// A failure rolls back all of them, so migrations without a transaction
+// or with an isolation level are not allowed.
+func (c *Collection) upAtomic(
+	ctx context.Context, db DB, tx *pg.Tx, res *RunResult,
+	migrations []*Migration, version, target int64, n int,
+) (newVersion int64, err error) {
+	var applied map[int64]*appliedMigration
+	if c.outOfOrder {
+		applied, err = c.appliedVersions(tx, migrations)
+		if err != nil {
+			return version, err
+		}
+	}
+
+	var batch []*Migration
+	for _, m := range migrations {
+		if m.Version > target || (n > 0 && len(batch) == n) {
+			break
+		}
+		if applied != nil {
+			if applied[m.Version] != nil {
+				continue
+			}
+		} else if m.Version <= version {
+			continue
+		}
+
+		if !m.UpTx {
+			return version, fmt.Errorf(
+				"migration=%d can't be applied atomically: it runs without a transaction", m.Version)
+		}
+		if m.upIsolation != "" {
+			return version, fmt.Errorf(
+				"migration=%d can't be applied atomically: it requires isolation level %s",
+				m.Version, m.upIsolation)
+		}
+		batch = append(batch, m)
+	}
+
+	// Migrations rolled back with the batch are not reported as executed.
+	executed := len(res.Migrations)
+	defer func() {
+		if err != nil {
+			res.Migrations = res.Migrations[:executed]
+		}
+	}()
+
+	newVersion = version
+	for _, m := range batch {
+		if err = ctx.Err(); err != nil {
+			return version, err
+		}
+
+		_, newVersion, err = c.runUp(ctx, db, tx, res, m, newVersion)
+		if err != nil {
+			return version, err
+		}
+	}
+
+	return newVersion, nil
+}
+
+// downTo reverts migrations with versions higher than the target committing
+// after each migration. n limits the number of migrations unless it is zero.
+func (c *Collection) downTo(
+	ctx context.Context, db DB, tx *pg.Tx, res *RunResult,
+	migrations []*Migration, version, target int64, n int,
+) (_ *pg.Tx, newVersion int64, err error) {
+	newVersion = version
+
+	for count := 0; n == 0 || count < n; count++ {
+		if err = ctx.Err(); err != nil {
+			return tx, newVersion, err
+		}
+
+		if tx == nil {
+			tx, version, err = c.begin(ctx, db, "")
+			if err != nil {
+				return nil, newVersion, err
+			}
+		}
+
+		tx, newVersion, err = c.down(ctx, db, tx, res, migrations, version, target)
+		if err != nil {
+			return tx, newVersion, err
+		}
+		if newVersion == version {
+			break
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return tx, version, err
+		}
+		tx = nil
+		version = newVersion
+	}
+
+	return tx, newVersion, nil
+}
+
+// redo reverts the last n applied migrations and applies them again.
+// With a locker it commits after each migration, otherwise migrations
+// are redone in the single transaction tx that holds the table lock.
+func (c *Collection) redo(
+	ctx context.Context, db DB, tx *pg.Tx, res *RunResult,
+	migrations []*Migration, version int64, n int,
+) (_ *pg.Tx, newVersion int64, err error) {
+	ms, err := c.lastAppliedN(tx, migrations, version, n)
+	if err != nil {
+		return tx, version, err
+	}
+	if len(ms) < n {
+		return tx, version, fmt.Errorf(
+			"redo %d: only %d migrations are applied", n, len(ms))
+	}
+	for _, m := range ms {
+		if m.Down == nil && !c.isIrreversible(m) {
+			return tx, version, fmt.Errorf(
+				"migration=%d can't be redone: it has no Down func", m.Version)
+		}
+		if err := c.checkReversible(m); err != nil {
+			return tx, version, err
+		}
+	}
+
+	if c.singleTx(res.Command) {
+		newVersion, err = c.redoTx(ctx, db, tx, res, ms, version)
+		return tx, newVersion, err
+	}
+
+	tx, version, err = c.downTo(
+		ctx, db, tx, res, migrations, version, ms[len(ms)-1].Version-1, n)
+	if err != nil {
+		return tx, version, err
+	}
+	newVersion = version
+
+	for i := len(ms) - 1; i >= 0; i-- {
+		m := ms[i]
+
+		if err = ctx.Err(); err != nil {
+			return tx, newVersion, err
+		}
+
+		if tx == nil || m.upIsolation != "" {
+			if tx != nil {
+				_ = tx.Rollback()
+			}
+			tx, version, err = c.begin(ctx, db, m.upIsolation)
+			if err != nil {
+				return nil, newVersion, err
+			}
+		}
+
+		tx, newVersion, err = c.runUp(ctx, db, tx, res, m, version)
+		if err != nil {
+			return tx, newVersion, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return tx, version, err
+		}
+		tx = nil
+	}
+
+	return tx, newVersion, nil
+}
+
+// redoTx reverts and applies again migrations ms, sorted by version
+// in descending order, in the transaction tx.
+func (c *Collection) redoTx(
+	ctx context.Context, db DB, tx *pg.Tx, res *RunResult, ms []*Migration, version int64,
+) (newVersion int64, err error) {
+	for _, m := range ms {
+		if !m.DownTx || !m.UpTx {
+			return version, fmt.Errorf(
+				"migration=%d runs without a transaction, so redo requires a locker; "+
+					"call Collection.SetLocker, e.g. with NewAdvisoryLocker", m.Version)
+		}
+		if m.downIsolation != "" || m.upIsolation != "" {
+			return version, fmt.Errorf(
+				"migration=%d requires an isolation level, so redo requires a locker; "+
+					"call Collection.SetLocker, e.g. with NewAdvisoryLocker", m.Version)
+		}
+	}
+
+	// Migrations rolled back with the transaction are not reported as executed.
+	executed := len(res.Migrations)
+	defer func() {
+		if err != nil {
+			res.Migrations = res.Migrations[:executed]
+		}
+	}()
+
+	newVersion = version
+	for _, m := range ms {
+		if err = ctx.Err(); err != nil {
+			return version, err
+		}
+		_, newVersion, err = c.runDown(ctx, db, tx, res, m, newVersion)
+		if err != nil {
+			return version, err
+		}
+	}
+	for i := len(ms) - 1; i >= 0; i-- {
+		if err = ctx.Err(); err != nil {
+			return version, err
+		}
+		_, newVersion, err = c.runUp(ctx, db, tx, res, ms[i], newVersion)
+		if err != nil {
+			return version, err
+		}
+	}
+
+	return newVersion, nil
+}
+
+// singleTx reports whether the command runs all migrations in one
+// transaction, so a failed migration rolls back the others.
+func (c *Collection) singleTx(cmd Command) bool {
+	return cmd.Options.Atomic || (cmd.Name == "redo" && c.locker == nil)
+}
+
+func (c *Collection) runUp(
+	ctx context.Context, db DB, tx *pg.Tx, res *RunResult, m *Migration, version int64,
+) (*pg.Tx, int64, error) {
+	if !m.UpTx {
+		var err error
+		tx, err = c.markDirty(ctx, db, tx, m, "up", version)
+		if err != nil {
+			return tx, version, err
+		}
 	}
-	return c.run(tx, func() (int64, error) {
-		err := m.Up(db)
+	return c.runRetry(ctx, db, tx, res, m, "up", version, func(mdb DB) (int64, error) {
+		err := m.Up(mdb)
 		if err != nil {
 			return 0, err
 		}
+		// Out of order migrations don't lower the version.
+		if m.Version < version {
+			return version, nil
+		}
 		return m.Version, nil
 	})
 }
 
-func (c *Collection) runDown(db DB, tx *pg.Tx, m *Migration) (int64, error) {
-	if m.DownTx {
-		db = tx
+func (c *Collection) runDown(
+	ctx context.Context, db DB, tx *pg.Tx, res *RunResult, m *Migration, version int64,
+) (*pg.Tx, int64, error) {
+	if !m.DownTx && m.Down != nil {
+		var err error
+		tx, err = c.markDirty(ctx, db, tx, m, "down", version)
+		if err != nil {
+			return tx, version, err
+		}
 	}
-	return c.run(tx, func() (int64, error) {
+	return c.runRetry(ctx, db, tx, res, m, "down", version, func(mdb DB) (int64, error) {
 		if m.Down != nil {
-			err := m.Down(db)
+			err := m.Down(mdb)
 			if err != nil {
 				return 0, err
 			}
@@ -574,35 +1387,167 @@ func (c *Collection) runDown(db DB, tx *pg.Tx, m *Migration) (int64, error) {
 	})
 }
 
+// markDirty records that the non-transactional migration is started,
+// so the database stays dirty if the migration fails or is interrupted.
+// The marker is committed and the migrations table is locked again
+// in a new transaction.
+func (c *Collection) markDirty(
+	ctx context.Context, db DB, tx *pg.Tx, m *Migration, direction string, version int64,
+) (*pg.Tx, error) {
+	err := c.insertHistory(tx, &historyRecord{
+		migration: m,
+		direction: direction,
+		dirty:     true,
+	})
+	if err != nil {
+		return tx, err
+	}
+	if err := tx.Commit(); err != nil {
+		return tx, err
+	}
+
+	tx, newVersion, err := c.begin(ctx, db, "")
+	if err != nil {
+		return nil, err
+	}
+	if newVersion != version {
+		return tx, fmt.Errorf(
+			"version was changed from %d to %d by another run", version, newVersion)
+	}
+	return tx, nil
+}
+
+// run runs the migration and records it in the history. On error
+// it returns the version the database had before the migration.
 func (c *Collection) run(
-	tx *pg.Tx, fn func() (int64, error),
+	db DB, tx *pg.Tx, res *RunResult, m *Migration, direction string, version int64,
+	fn func() (int64, error),
 ) (newVersion int64, err error) {
+	ctx := tx.Context()
+	c.beforeMigration(ctx, m, direction)
+
+	start := time.Now()
 	newVersion, err = fn()
+	rec := &historyRecord{
+		migration: m,
+		direction: direction,
+		duration:  time.Since(start),
+	}
 	if err != nil {
-		return
+		c.onError(ctx, m, direction, err)
+		// The failed migration may have aborted the transaction,
+		// so the failure is recorded after the rollback.
+		_ = tx.Rollback()
+		_ = c.insertHistory(db, rec)
+		return version, err
 	}
-	err = c.SetVersion(tx, newVersion)
-	return
+
+	rec.version = newVersion
+	rec.success = true
+	if err = c.insertHistory(tx, rec); err != nil {
+		return version, err
+	}
+
+	executed := &ExecutedMigration{
+		Migration: m,
+		Direction: direction,
+		Duration:  rec.duration,
+	}
+	res.Migrations = append(res.Migrations, executed)
+	// Migrations run in one transaction are reported after the commit.
+	if !c.singleTx(res.Command) {
+		c.afterMigration(ctx, executed)
+	}
+	return newVersion, nil
 }
 
-func (c *Collection) down(db DB, tx *pg.Tx, migrations []*Migration, oldVersion int64) (int64, error) {
-	if oldVersion == 0 {
-		return 0, nil
+// down reverts the last applied migration. It returns the transaction
+// that must be committed, which differs from tx when the migration
+// requires an isolation level.
+func (c *Collection) down(
+	ctx context.Context, db DB, tx *pg.Tx, res *RunResult,
+	migrations []*Migration, oldVersion, target int64,
+) (*pg.Tx, int64, error) {
+	m, err := c.lastApplied(tx, migrations, oldVersion)
+	if err != nil {
+		return tx, oldVersion, err
+	}
+	if m == nil || m.Version <= target {
+		return tx, oldVersion, nil
+	}
+	if err := c.checkReversible(m); err != nil {
+		return tx, oldVersion, err
 	}
 
-	var m *Migration
-	for i := len(migrations) - 1; i >= 0; i-- {
-		mm := migrations[i]
-		if mm.Version <= oldVersion {
-			m = mm
-			break
+	if m.downIsolation != "" {
+		_ = tx.Rollback()
+
+		var version int64
+		tx, version, err = c.begin(ctx, db, m.downIsolation)
+		if err != nil {
+			return nil, oldVersion, err
+		}
+		if version != oldVersion {
+			return tx, oldVersion, fmt.Errorf(
+				"version was changed from %d to %d by another run", oldVersion, version)
+		}
+	}
+
+	return c.runDown(ctx, db, tx, res, m, oldVersion)
+}
+
+// checkReversible returns ErrIrreversible if the migration can't be reverted.
+func (c *Collection) checkReversible(m *Migration) error {
+	if c.isIrreversible(m) {
+		return fmt.Errorf("migration=%d can't be reverted: %w", m.Version, ErrIrreversible)
+	}
+	if c.downRequired && m.Down == nil {
+		return fmt.Errorf(
+			"migration=%d has no Down func and can't be reverted: %w", m.Version, ErrIrreversible)
+	}
+	return nil
+}
+
+func (c *Collection) isIrreversible(m *Migration) bool {
+	return m.Irreversible || c.irreversible[m.Version]
+}
+
+// lastApplied returns the migration that is reverted by down.
+func (c *Collection) lastApplied(
+	db DB, migrations []*Migration, version int64,
+) (*Migration, error) {
+	ms, err := c.lastAppliedN(db, migrations, version, 1)
+	if err != nil || len(ms) == 0 {
+		return nil, err
+	}
+	return ms[0], nil
+}
+
+// lastAppliedN returns up to n last applied migrations, the last one first.
+func (c *Collection) lastAppliedN(
+	db DB, migrations []*Migration, version int64, n int,
+) ([]*Migration, error) {
+	if version == 0 {
+		return nil, nil
+	}
+
+	var applied map[int64]*appliedMigration
+	if c.outOfOrder {
+		var err error
+		applied, err = c.appliedVersions(db, migrations)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	if m == nil {
-		return oldVersion, nil
+	var ms []*Migration
+	for i := len(migrations) - 1; i >= 0 && len(ms) < n; i-- {
+		m := migrations[i]
+		if m.Version <= version && (applied == nil || applied[m.Version] != nil) {
+			ms = append(ms, m)
+		}
 	}
-	return c.runDown(db, tx, m)
+	return ms, nil
 }
 
 func (c *Collection) schemaExists(db DB) (bool, error) {
@@ -622,10 +1567,26 @@ func (c *Collection) tableExists(db DB) (bool, error) {
 		Exists()
 }
 
+func (c *Collection) columnExists(db DB, column string) (bool, error) {
+	schema, table := c.schemaTableName()
+	var exists bool
+	_, err := db.QueryOne(pg.Scan(&exists), `
+		SELECT EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_schema = ? AND table_name = ? AND column_name = ?
+		)
+	`, schema, table, column)
+	return exists, err
+}
+
 func (c *Collection) Version(db DB) (int64, error) {
+	if err := c.upgradeTable(db); err != nil {
+		return 0, err
+	}
+
 	var version int64
 	_, err := db.QueryOne(pg.Scan(&version), `
-		SELECT version FROM ? ORDER BY id DESC LIMIT 1
+		SELECT version FROM ? WHERE success ORDER BY id DESC LIMIT 1
 	`, pg.SafeQuery(c.tableName))
 	if err != nil {
 		if err == pg.ErrNoRows {
@@ -636,13 +1597,331 @@ func (c *Collection) Version(db DB) (int64, error) {
 	return version, nil
 }
 
+// dirtyMigration describes the non-transactional migration that was started,
+// but did not complete.
+type dirtyMigration struct {
+	version   int64
+	direction string
+}
+
+func (d *dirtyMigration) err() error {
+	return fmt.Errorf(
+		"%w: migration=%d %s did not complete; fix the database and run force <version>",
+		ErrDirty, d.version, d.direction)
+}
+
+// dirtyMigration returns the dirty migration or nil. Successful runs
+// and set_version clear the marker of the previous dirty migration.
+func (c *Collection) dirtyMigration(db DB) (*dirtyMigration, error) {
+	var dirty bool
+	d := new(dirtyMigration)
+	_, err := db.QueryOne(pg.Scan(&dirty, &d.version, &d.direction), `
+		SELECT dirty, coalesce(migration, 0), coalesce(direction, '')
+		FROM ? WHERE success OR dirty ORDER BY id DESC LIMIT 1
+	`, pg.SafeQuery(c.tableName))
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !dirty {
+		return nil, nil
+	}
+	return d, nil
+}
+
 func (c *Collection) SetVersion(db DB, version int64) error {
+	if err := c.upgradeTable(db); err != nil {
+		return err
+	}
+	return c.insertHistory(db, &historyRecord{
+		version: version,
+		success: true,
+	})
+}
+
+// ChecksumMismatch describes an applied SQL migration
+// that was changed after it had been applied.
+type ChecksumMismatch struct {
+	Version int64
+	Name    string
+
+	// Applied is the checksum recorded in the database.
+	Applied string
+	// Current is the checksum of the migration file.
+	Current string
+}
+
+func (m *ChecksumMismatch) String() string {
+	return fmt.Sprintf(
+		"migration=%d %s has checksum %s, but %s was applied",
+		m.Version, m.Name, m.Current, m.Applied)
+}
+
+// Verify compares checksums of SQL migration files with the checksums
+// recorded in the database when migrations were applied and returns
+// the applied migrations that were changed since.
+func (c *Collection) Verify(db DB) ([]*ChecksumMismatch, error) {
+	migrations, err := c.discoverMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.upgradeTable(db); err != nil {
+		return nil, err
+	}
+	return c.verify(db, migrations)
+}
+
+func (c *Collection) verify(db DB, migrations []*Migration) ([]*ChecksumMismatch, error) {
+	var rows []struct {
+		Migration int64
+		Direction string
+		Checksum  string
+	}
+	_, err := db.Query(&rows, `
+		SELECT DISTINCT ON (migration) migration, direction, checksum
+		FROM ?
+		WHERE success AND migration IS NOT NULL
+		ORDER BY migration, id DESC
+	`, pg.SafeQuery(c.tableName))
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]string, len(rows))
+	for _, row := range rows {
+		if row.Direction == "up" && row.Checksum != "" {
+			applied[row.Migration] = row.Checksum
+		}
+	}
+
+	var mismatches []*ChecksumMismatch
+	for _, m := range migrations {
+		checksum, ok := applied[m.Version]
+		if !ok || m.upChecksum == "" || checksum == m.upChecksum {
+			continue
+		}
+		mismatches = append(mismatches, &ChecksumMismatch{
+			Version: m.Version,
+			Name:    m.Name,
+			Applied: checksum,
+			Current: m.upChecksum,
+		})
+	}
+	return mismatches, nil
+}
+
+func (c *Collection) verifyChecksums(db DB, migrations []*Migration) error {
+	mismatches, err := c.verify(db, migrations)
+	if err != nil {
+		return err
+	}
+	if len(mismatches) == 0 {
+		return nil
+	}
+
+	ss := make([]string, len(mismatches))
+	for i, m := range mismatches {
+		ss[i] = m.String()
+	}
+	return fmt.Errorf("applied migrations were changed: %s", strings.Join(ss, "; "))
+}
+
+// PendingMigration is a migration that is not applied yet.
+type PendingMigration struct {
+	*Migration
+
+	// OutOfOrder is true when a migration with a higher version is already
+	// applied. Such migrations are applied by up only when
+	// AllowOutOfOrder is set.
+	OutOfOrder bool
+}
+
+func (m *PendingMigration) String() string {
+	s := fmt.Sprintf("migration=%d %s is pending", m.Version, m.Name)
+	if m.OutOfOrder {
+		s += " (out of order)"
+	}
+	return s
+}
+
+// Pending returns migrations that are not applied yet,
+// including the ones skipped over by up.
+func (c *Collection) Pending(db DB) ([]*PendingMigration, error) {
+	version, err := c.Version(db)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := c.discoverMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return c.pending(db, migrations, version)
+}
+
+func (c *Collection) pending(
+	db DB, migrations []*Migration, version int64,
+) ([]*PendingMigration, error) {
+	applied, err := c.appliedVersions(db, migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []*PendingMigration
+	for _, m := range migrations {
+		if applied[m.Version] != nil {
+			continue
+		}
+		pending = append(pending, &PendingMigration{
+			Migration:  m,
+			OutOfOrder: m.Version < version,
+		})
+	}
+	return pending, nil
+}
+
+type appliedMigration struct {
+	name        string
+	description string
+	appliedAt   time.Time
+}
+
+// appliedVersions replays the migrations table and returns the applied
+// migrations by version. Rows without a migration, e.g. written by
+// SetVersion, mark all migrations up to the version as applied.
+func (c *Collection) appliedVersions(
+	db DB, migrations []*Migration,
+) (map[int64]*appliedMigration, error) {
+	var rows []struct {
+		Version     int64
+		Migration   *int64
+		Name        string
+		Description string
+		Direction   string
+		CreatedAt   time.Time
+	}
+	_, err := db.Query(&rows, `
+		SELECT version, migration, name, description, direction, created_at
+		FROM ?
+		WHERE success
+		ORDER BY id
+	`, pg.SafeQuery(c.tableName))
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]*appliedMigration)
+	for _, row := range rows {
+		if row.Migration == nil {
+			applied = make(map[int64]*appliedMigration)
+			for _, m := range migrations {
+				if m.Version <= row.Version {
+					applied[m.Version] = &appliedMigration{
+						name:        m.Name,
+						description: m.Description,
+						appliedAt:   row.CreatedAt,
+					}
+				}
+			}
+			continue
+		}
+
+		switch row.Direction {
+		case "up":
+			applied[*row.Migration] = &appliedMigration{
+				name:        row.Name,
+				description: row.Description,
+				appliedAt:   row.CreatedAt,
+			}
+		case "down":
+			delete(applied, *row.Migration)
+		}
+	}
+	return applied, nil
+}
+
+// historyRecord is a row of the migrations table. Rows without
+// a migration are written by SetVersion.
+type historyRecord struct {
+	version   int64
+	migration *Migration
+	direction string
+	duration  time.Duration
+	success   bool
+	// dirty marks the start of a non-transactional migration.
+	dirty bool
+}
+
+func (c *Collection) insertHistory(db DB, rec *historyRecord) error {
+	var version, migration, name, description, direction, checksum, duration interface{}
+	if rec.success {
+		version = rec.version
+	}
+	if m := rec.migration; m != nil {
+		migration = m.Version
+		name = m.Name
+		if m.Description != "" {
+			description = m.Description
+		}
+		direction = rec.direction
+		if direction == "up" && m.upChecksum != "" {
+			checksum = m.upChecksum
+		} else if direction == "down" && m.downChecksum != "" {
+			checksum = m.downChecksum
+		}
+		duration = rec.duration.Milliseconds()
+	}
+	hostname, _ := os.Hostname()
+
 	_, err := db.Exec(`
-		INSERT INTO ? (version, created_at) VALUES (?, now())
-	`, pg.SafeQuery(c.tableName), version)
+		INSERT INTO ? (
+			version, migration, name, description, direction, checksum,
+			duration_ms, hostname, username, success, dirty, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, current_user, ?, ?, now())
+	`, pg.SafeQuery(c.tableName),
+		version, migration, name, description, direction, checksum, duration,
+		hostname, rec.success, rec.dirty)
 	return err
 }
 
+// upgradeTable adds missing columns to the migrations table. ALTER TABLE
+// waits for the table lock held by concurrent runs.
+// upgradeTable adds the missing columns to the table created by an older
+// version, so it can be queried by Version and other methods before
+// any command is run. The table is checked once.
+func (c *Collection) upgradeTable(db DB) error {
+	c.mu.Lock()
+	upgraded := c.tableUpgraded
+	c.mu.Unlock()
+	if upgraded {
+		return nil
+	}
+
+	exists, err := c.columnExists(db, "dirty")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		exists, err = c.tableExists(db)
+		if err != nil {
+			return err
+		}
+		// The table is created by init.
+		if !exists {
+			return nil
+		}
+		if err := c.createTable(db); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	c.tableUpgraded = true
+	c.mu.Unlock()
+	return nil
+}
+
 func (c *Collection) createTable(db DB) error {
 	exists, err := c.schemaExists(db)
 	if err != nil {
@@ -663,6 +1942,25 @@ func (c *Collection) createTable(db DB) error {
 			created_at timestamptz
 		)
 	`, pg.SafeQuery(c.tableName))
+	if err != nil {
+		return err
+	}
+
+	// Columns are added separately so tables created
+	// by older versions are upgraded in place.
+	_, err = db.Exec(`
+		ALTER TABLE ?
+			ADD COLUMN IF NOT EXISTS migration bigint,
+			ADD COLUMN IF NOT EXISTS name text,
+			ADD COLUMN IF NOT EXISTS direction text,
+			ADD COLUMN IF NOT EXISTS checksum text,
+			ADD COLUMN IF NOT EXISTS duration_ms bigint,
+			ADD COLUMN IF NOT EXISTS hostname text,
+			ADD COLUMN IF NOT EXISTS username text,
+			ADD COLUMN IF NOT EXISTS success boolean NOT NULL DEFAULT true,
+			ADD COLUMN IF NOT EXISTS description text,
+			ADD COLUMN IF NOT EXISTS dirty boolean NOT NULL DEFAULT false
+	`, pg.SafeQuery(c.tableName))
 	return err
 }
 
@@ -671,8 +1969,16 @@ const (
 	yugabytedbErrorMatch  = `lock mode not supported yet`
 )
 
-func (c *Collection) begin(db DB) (*pg.Tx, int64, error) {
-	tx, err := db.Begin()
+func (c *Collection) begin(
+	ctx context.Context, db DB, isolation string,
+) (tx *pg.Tx, version int64, err error) {
+	defer func() {
+		if err != nil {
+			c.onError(ctx, nil, "", err)
+		}
+	}()
+
+	tx, err = beginContext(ctx, db)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -683,27 +1989,41 @@ func (c *Collection) begin(db DB) (*pg.Tx, int64, error) {
 	if err != nil {
 		_ = tx.Rollback()
 
-		tx, err = db.Begin()
+		tx, err = beginContext(ctx, db)
 		if err != nil {
 			return nil, 0, err
 		}
 	}
-	// If there is an error setting this, rollback the transaction and don't bother doing it
-	// because neither CockroachDB nor Yugabyte support it
-	_, err = tx.Exec("LOCK TABLE ? IN EXCLUSIVE MODE", pg.SafeQuery(c.tableName))
-	if err != nil {
-		_ = tx.Rollback()
-
-		if !strings.Contains(err.Error(), cockroachdbErrorMatch) && !strings.Contains(err.Error(), yugabytedbErrorMatch) {
+	if isolation != "" {
+		_, err = tx.Exec("SET TRANSACTION ISOLATION LEVEL ?", pg.SafeQuery(isolation))
+		if err != nil {
+			_ = tx.Rollback()
 			return nil, 0, err
 		}
-		tx, err = db.Begin()
+	}
+
+	// The table lock is not needed when the whole run is protected by the locker.
+	if c.locker == nil {
+		// If there is an error setting this, rollback the transaction and don't bother doing it
+		// because neither CockroachDB nor Yugabyte support it
+		err = c.waitLock(ctx, c.tableName, func() error {
+			_, err := tx.Exec("LOCK TABLE ? IN EXCLUSIVE MODE", pg.SafeQuery(c.tableName))
+			return err
+		})
 		if err != nil {
-			return nil, 0, err
+			_ = tx.Rollback()
+
+			if !strings.Contains(err.Error(), cockroachdbErrorMatch) && !strings.Contains(err.Error(), yugabytedbErrorMatch) {
+				return nil, 0, err
+			}
+			tx, err = beginContext(ctx, db)
+			if err != nil {
+				return nil, 0, err
+			}
 		}
 	}
 
-	version, err := c.Version(tx)
+	version, err = c.Version(tx)
 	if err != nil {
 		_ = tx.Rollback()
 		return nil, 0, err
@@ -712,10 +2032,33 @@ func (c *Collection) begin(db DB) (*pg.Tx, int64, error) {
 	return tx, version, nil
 }
 
-func extractVersionGo(name string) (int64, error) {
+// beginContext starts a transaction that uses the ctx.
+// Transactions can't be nested, so db.Begin is used for *pg.Tx.
+func beginContext(ctx context.Context, db DB) (*pg.Tx, error) {
+	if db, ok := db.(interface {
+		BeginContext(context.Context) (*pg.Tx, error)
+	}); ok {
+		return db.BeginContext(ctx)
+	}
+	return db.Begin()
+}
+
+// withContext returns a copy of the db that uses the ctx.
+// The context of *pg.Tx can't be changed.
+func withContext(ctx context.Context, db DB) DB {
+	switch v := db.(type) {
+	case *pg.DB:
+		return v.WithContext(ctx)
+	case *pg.Conn:
+		return v.WithContext(ctx)
+	}
+	return db
+}
+
+func extractVersionGo(name string) (int64, string, error) {
 	base := filepath.Base(name)
 	if !strings.HasSuffix(name, ".go") {
-		return 0, fmt.Errorf("file=%q must have extension .go", base)
+		return 0, "", fmt.Errorf("file=%q must have extension .go", base)
 	}
 
 	idx := strings.IndexByte(base, '_')
@@ -723,15 +2066,15 @@ func extractVersionGo(name string) (int64, error) {
 		err := fmt.Errorf(
 			"file=%q must have name in format version_comment, e.g. 1_initial",
 			base)
-		return 0, err
+		return 0, "", err
 	}
 
 	n, err := strconv.ParseInt(base[:idx], 10, 64)
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
 
-	return n, nil
+	return n, strings.TrimSuffix(base[idx+1:], ".go"), nil
 }
 
 var migrationNameRE = regexp.MustCompile(`[^a-z0-9]+`)
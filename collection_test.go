@@ -0,0 +1,61 @@
+package migrations
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRegisterVersion(t *testing.T) {
+	coll := NewCollection()
+	coll.DisableSQLAutodiscover(true)
+
+	noop := func(DB) error { return nil }
+	for _, m := range []struct {
+		version int64
+		name    string
+	}{
+		{2, "add_email"},
+		{1, "initial"},
+	} {
+		if err := coll.RegisterVersionTx(m.version, m.name, noop, noop); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ms := coll.Migrations()
+	if len(ms) != 2 {
+		t.Fatalf("got %d migrations, wanted 2", len(ms))
+	}
+	if ms[0].Version != 1 || ms[0].Name != "initial" || !ms[0].UpTx || !ms[0].DownTx {
+		t.Fatalf("got %+v", ms[0])
+	}
+	if ms[1].Version != 2 || ms[1].Name != "add_email" {
+		t.Fatalf("got %+v", ms[1])
+	}
+
+	if err := coll.RegisterVersion(0, "zero", noop, nil); err == nil {
+		t.Fatal("version 0 is accepted")
+	}
+	if err := coll.RegisterVersion(3, "no_up", nil, noop); err == nil {
+		t.Fatal("migration without up func is accepted")
+	}
+}
+
+func TestSetIrreversible(t *testing.T) {
+	coll := NewCollection()
+	coll.DisableSQLAutodiscover(true)
+
+	noop := func(DB) error { return nil }
+	if err := coll.RegisterVersion(1, "drop_users", noop, noop); err != nil {
+		t.Fatal(err)
+	}
+	m := coll.Migrations()[0]
+	if err := coll.checkReversible(m); err != nil {
+		t.Fatal(err)
+	}
+
+	coll.SetIrreversible(1)
+	if err := coll.checkReversible(m); !errors.Is(err, ErrIrreversible) {
+		t.Fatalf("got %v, wanted ErrIrreversible", err)
+	}
+}
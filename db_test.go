@@ -1,8 +1,14 @@
 package migrations_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/go-pg/migrations/v8"
 
@@ -107,6 +113,442 @@ func TestUpDown(t *testing.T) {
 	}
 }
 
+func TestExec(t *testing.T) {
+	db := connectDB()
+
+	coll := migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, Name: "first", Up: doNothing, Down: doNothing},
+		{Version: 2, Name: "second", Up: doNothing, Down: doNothing},
+	}...)
+	res, err := coll.Exec(context.Background(), db, migrations.Command{Name: "up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.OldVersion != 0 || res.NewVersion != 2 {
+		t.Fatalf("got versions %d and %d, wanted 0 and 2", res.OldVersion, res.NewVersion)
+	}
+	if len(res.Migrations) != 2 || res.Migrations[1].Name != "second" ||
+		res.Migrations[1].Direction != "up" {
+		t.Fatalf("got %v, wanted migrations 1 and 2 applied", res.Migrations)
+	}
+
+	res, err = coll.Exec(context.Background(), db, migrations.ParseCommand("up", "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Migrations) != 0 || len(res.Warnings) != 1 {
+		t.Fatalf("got %v and warnings %q", res.Migrations, res.Warnings)
+	}
+
+	res, err = coll.Exec(context.Background(), db, migrations.Command{Name: "down"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Migrations) != 1 || res.Migrations[0].Version != 2 ||
+		res.Migrations[0].Direction != "down" {
+		t.Fatalf("got %v, wanted migration 2 reverted", res.Migrations)
+	}
+
+	if err := coll.RegisterVersion(3, "third", doFail, doNothing); err != nil {
+		t.Fatal(err)
+	}
+	res, err = coll.Exec(context.Background(), db, migrations.Command{Name: "up"})
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	if res.OldVersion != 1 || res.NewVersion != 2 {
+		t.Fatalf("got versions %d and %d, wanted 1 and 2", res.OldVersion, res.NewVersion)
+	}
+	if len(res.Migrations) != 1 || res.Migrations[0].Version != 2 {
+		t.Fatalf("got %v, wanted migration 2 applied", res.Migrations)
+	}
+}
+
+func TestSteps(t *testing.T) {
+	db := connectDB()
+
+	coll := migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, Up: doNothing, Down: doNothing},
+		{Version: 2, Up: doNothing, Down: doNothing},
+		{Version: 3, Up: doNothing, Down: doNothing},
+		{Version: 5, Up: doNothing, Down: doNothing},
+	}...)
+
+	tests := []struct {
+		args    []string
+		version int64
+	}{
+		{[]string{"up", "+2"}, 2},
+		{[]string{"goto", "5"}, 5},
+		{[]string{"down", "2"}, 2},
+		{[]string{"up", "+1"}, 3},
+		{[]string{"down-to", "1"}, 1},
+		{[]string{"goto", "4"}, 3},
+		{[]string{"goto", "0"}, 0},
+	}
+	for _, test := range tests {
+		_, newVersion, err := coll.Run(db, test.args...)
+		if err != nil {
+			t.Fatalf("%q: %s", test.args, err)
+		}
+		if newVersion != test.version {
+			t.Fatalf("%q: got version %d, wanted %d", test.args, newVersion, test.version)
+		}
+	}
+}
+
+func TestRedo(t *testing.T) {
+	db := connectDB()
+
+	var events []string
+	record := func(event string) func(migrations.DB) error {
+		return func(migrations.DB) error {
+			events = append(events, event)
+			return nil
+		}
+	}
+	coll := migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, Up: record("up 1")},
+		{Version: 2, UpTx: true, Up: record("up 2"), DownTx: true, Down: record("down 2")},
+		{Version: 3, UpTx: true, Up: record("up 3"), DownTx: true, Down: record("down 3")},
+	}...)
+	_, _, err := coll.Run(db, "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events = nil
+	_, newVersion, err := coll.Run(db, "redo", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newVersion != 3 {
+		t.Fatalf("got version %d, wanted 3", newVersion)
+	}
+	wanted := []string{"down 3", "down 2", "up 2", "up 3"}
+	if !reflect.DeepEqual(events, wanted) {
+		t.Fatalf("got %q, wanted %q", events, wanted)
+	}
+
+	events = nil
+	_, _, err = coll.Run(db, "redo", "3")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(events) != 0 {
+		t.Fatalf("got %q, wanted no migrations to run", events)
+	}
+
+	// Without a locker non-transactional migrations can't be redone.
+	err = coll.RegisterVersion(4, "notx", record("up 4"), record("down 4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = coll.Run(db, "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events = nil
+	_, _, err = coll.Run(db, "redo")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(events) != 0 {
+		t.Fatalf("got %q, wanted no migrations to run", events)
+	}
+
+	coll.SetLocker(migrations.NewAdvisoryLocker(4242))
+	_, _, err = coll.Run(db, "redo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wanted = []string{"down 4", "up 4"}
+	if !reflect.DeepEqual(events, wanted) {
+		t.Fatalf("got %q, wanted %q", events, wanted)
+	}
+}
+
+func TestIrreversible(t *testing.T) {
+	db := connectDB()
+
+	noop := func(migrations.DB) error { return nil }
+	coll := migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, Up: noop},
+		{Version: 2, Up: noop, Irreversible: true},
+		{Version: 3, Up: noop, Down: noop},
+	}...)
+	_, _, err := coll.Run(db, "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, newVersion, err := coll.Run(db, "reset")
+	if !errors.Is(err, migrations.ErrIrreversible) {
+		t.Fatalf("got %v, wanted ErrIrreversible", err)
+	}
+	if newVersion != 2 {
+		t.Fatalf("got version %d, wanted 2", newVersion)
+	}
+
+	coll.RequireDown(true)
+	_, err = db.Exec("TRUNCATE gopg_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = coll.SetVersion(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, newVersion, err = coll.Run(db, "down")
+	if !errors.Is(err, migrations.ErrIrreversible) {
+		t.Fatalf("got %v, wanted ErrIrreversible", err)
+	}
+	if newVersion != 1 {
+		t.Fatalf("got version %d, wanted 1", newVersion)
+	}
+}
+
+func TestDirty(t *testing.T) {
+	db := connectDB()
+
+	fail := true
+	coll := migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, UpTx: true, Up: doNothing},
+		{Version: 2, Up: func(db migrations.DB) error {
+			if fail {
+				return fmt.Errorf("interrupted")
+			}
+			return nil
+		}},
+	}...)
+	ctx := context.Background()
+
+	_, err := coll.Exec(ctx, db, migrations.Command{Name: "up"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	fail = false
+	_, err = coll.Exec(ctx, db, migrations.Command{Name: "up"})
+	if !errors.Is(err, migrations.ErrDirty) {
+		t.Fatalf("got %v, wanted ErrDirty", err)
+	}
+
+	res, err := coll.Exec(ctx, db, migrations.Command{Name: "version"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.NewVersion != 1 || len(res.Warnings) != 1 {
+		t.Fatalf("got version %d and warnings %q", res.NewVersion, res.Warnings)
+	}
+
+	_, err = coll.Exec(ctx, db, migrations.ParseCommand("force", "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = coll.Exec(ctx, db, migrations.Command{Name: "up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.NewVersion != 2 {
+		t.Fatalf("got version %d, wanted 2", res.NewVersion)
+	}
+}
+
+func TestAtomic(t *testing.T) {
+	db := connectDB()
+
+	fail := true
+	coll := migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, UpTx: true, Up: doNothing},
+		{Version: 2, UpTx: true, Up: func(db migrations.DB) error {
+			if fail {
+				return fmt.Errorf("failed")
+			}
+			return nil
+		}},
+	}...)
+	o := new(recordingObserver)
+	coll.AddObserver(o)
+	ctx := context.Background()
+	cmd := migrations.ParseCommand("up", "--atomic")
+
+	res, err := coll.Exec(ctx, db, cmd)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(res.Migrations) != 0 {
+		t.Fatalf("got %v, wanted no migrations", res.Migrations)
+	}
+	wanted := []string{"before up 1", "before up 2", "error up 2"}
+	if !reflect.DeepEqual(o.events, wanted) {
+		t.Fatalf("got %q, wanted %q", o.events, wanted)
+	}
+	version, err := coll.Version(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Fatalf("got version %d, wanted 0", version)
+	}
+
+	fail = false
+	o.events = nil
+	res, err = coll.Exec(ctx, db, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.NewVersion != 2 || len(res.Migrations) != 2 {
+		t.Fatalf("got version %d and %v", res.NewVersion, res.Migrations)
+	}
+	wanted = []string{"before up 1", "before up 2", "after up 1", "after up 2"}
+	if !reflect.DeepEqual(o.events, wanted) {
+		t.Fatalf("got %q, wanted %q", o.events, wanted)
+	}
+
+	err = coll.RegisterVersion(3, "notx", func(migrations.DB) error {
+		t.Fatal("migration without transaction was run")
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = coll.Exec(ctx, db, cmd)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestAtomicSettings(t *testing.T) {
+	db := connectDB()
+
+	coll := migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, UpTx: true, Up: doNothing},
+		{Version: 2, UpTx: true, Up: func(db migrations.DB) error {
+			var lockTimeout string
+			_, err := db.QueryOne(pg.Scan(&lockTimeout), "SHOW lock_timeout")
+			if err != nil {
+				return err
+			}
+			if lockTimeout != "0" {
+				return fmt.Errorf("got lock_timeout %s, wanted 0", lockTimeout)
+			}
+			return nil
+		}},
+	}...)
+	coll.SetMigrationRetryPolicy(1, &migrations.RetryPolicy{LockTimeout: time.Second})
+
+	_, err := coll.Exec(context.Background(), db, migrations.ParseCommand("up", "--atomic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	db := connectDB()
+
+	var attempts int
+	coll := migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, UpTx: true, Up: func(db migrations.DB) error {
+			attempts++
+			if attempts < 3 {
+				_, err := db.Exec(`DO $$ BEGIN RAISE EXCEPTION USING ERRCODE = '55P03'; END $$`)
+				return err
+			}
+			var lockTimeout string
+			_, err := db.QueryOne(pg.Scan(&lockTimeout), "SHOW lock_timeout")
+			if err != nil {
+				return err
+			}
+			if lockTimeout != "2s" {
+				return fmt.Errorf("got lock_timeout %s, wanted 2s", lockTimeout)
+			}
+			return nil
+		}},
+	}...)
+	coll.SetRetryPolicy(&migrations.RetryPolicy{
+		LockTimeout: 2 * time.Second,
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+	})
+
+	_, newVersion, err := coll.Run(db, "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newVersion != 1 || attempts != 3 {
+		t.Fatalf("got version %d after %d attempts, wanted 1 after 3", newVersion, attempts)
+	}
+
+	// The policy of the migration overrides the policy of the collection.
+	attempts = 0
+	err = coll.RegisterVersionTx(2, "busy", func(db migrations.DB) error {
+		attempts++
+		_, err := db.Exec(`DO $$ BEGIN RAISE EXCEPTION USING ERRCODE = '55P03'; END $$`)
+		return err
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll.SetMigrationRetryPolicy(2, &migrations.RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond})
+
+	_, newVersion, err = coll.Run(db, "up")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if newVersion != 1 || attempts != 2 {
+		t.Fatalf("got version %d after %d attempts, wanted 1 after 2", newVersion, attempts)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	db := connectDB()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Close()
+
+	var pid int
+	_, err = tx.QueryOne(pg.Scan(&pid), "SELECT pg_backend_pid()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	coll := migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, Up: doNothing, Down: doNothing},
+	}...)
+	coll.SetPreflight(&migrations.Preflight{
+		MaxTransactionAge: time.Millisecond,
+		Cancel:            true,
+	})
+
+	_, err = coll.Exec(context.Background(), db, migrations.Command{Name: "up"})
+	var blockingErr *migrations.BlockingSessionsError
+	if !errors.As(err, &blockingErr) {
+		t.Fatalf("got %v, wanted BlockingSessionsError", err)
+	}
+	if len(blockingErr.Sessions) != 1 || blockingErr.Sessions[0].PID != pid {
+		t.Fatalf("got %v, wanted session with pid=%d", blockingErr.Sessions, pid)
+	}
+
+	coll.SetPreflight(&migrations.Preflight{
+		MaxTransactionAge: time.Millisecond,
+		Terminate:         true,
+	})
+	res, err := coll.Exec(context.Background(), db, migrations.Command{Name: "up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.NewVersion != 1 || len(res.Warnings) != 1 {
+		t.Fatalf("got version %d and warnings %q", res.NewVersion, res.Warnings)
+	}
+}
+
 func TestSetVersion(t *testing.T) {
 	db := connectDB()
 
@@ -142,10 +584,389 @@ func TestSetVersion(t *testing.T) {
 	}
 }
 
+func TestUpgradeTable(t *testing.T) {
+	db := connectDB()
+
+	// The table as created by v6.
+	_, err := db.Exec("DROP TABLE gopg_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE gopg_migrations (id serial, version bigint, created_at timestamptz);
+		INSERT INTO gopg_migrations (version, created_at) VALUES (1, now());
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coll := migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, Up: doNothing, Down: doNothing},
+		{Version: 2, Up: doNothing, Down: doNothing},
+	}...)
+	version, err := coll.Version(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Fatalf("got version %d, wanted 1", version)
+	}
+
+	oldVersion, newVersion, err := coll.Run(db, "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldVersion != 1 || newVersion != 2 {
+		t.Fatalf("got versions %d and %d, wanted 1 and 2", oldVersion, newVersion)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	db := connectDB()
+
+	coll := migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, Name: "first", Up: doNothing, Down: doNothing},
+		{Version: 2, Name: "second", Description: "second migration", Up: doNothing, Down: doNothing},
+		{Version: 3, Name: "broken", Up: doFail, Down: doNothing},
+	}...)
+	_, _, err := coll.Run(db, "up")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	var rows []struct {
+		Version     *int64
+		Migration   int64
+		Name        string
+		Description string
+		Direction   string
+		Success     bool
+	}
+	_, err = db.Query(&rows, `
+		SELECT version, migration, name, description, direction, success
+		FROM gopg_migrations ORDER BY id
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, wanted 3", len(rows))
+	}
+	if rows[1].Name != "second" || rows[1].Description != "second migration" ||
+		rows[1].Direction != "up" || !rows[1].Success {
+		t.Fatalf("unexpected row: %+v", rows[1])
+	}
+	if rows[2].Migration != 3 || rows[2].Success || rows[2].Version != nil {
+		t.Fatalf("unexpected row: %+v", rows[2])
+	}
+
+	version, err := coll.Version(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Fatalf("got version %d, wanted 2", version)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	db := connectDB()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "1_initial.up.sql")
+	if err := ioutil.WriteFile(file, []byte("SELECT 1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	coll := migrations.NewCollection()
+	coll.DisableSQLAutodiscover(true)
+	if err := coll.DiscoverSQLMigrations(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := coll.Run(db, "up"); err != nil {
+		t.Fatal(err)
+	}
+
+	mismatches, err := coll.Verify(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 0 {
+		t.Fatalf("got %d mismatches, wanted 0", len(mismatches))
+	}
+
+	if err := ioutil.WriteFile(file, []byte("SELECT 2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	coll = migrations.NewCollection()
+	coll.DisableSQLAutodiscover(true)
+	coll.RequireChecksums(true)
+	if err := coll.DiscoverSQLMigrations(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	mismatches, err = coll.Verify(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Version != 1 {
+		t.Fatalf("got %v, wanted mismatch for migration 1", mismatches)
+	}
+
+	if _, _, err := coll.Run(db, "verify"); err == nil {
+		t.Fatal("expected verify to fail")
+	}
+	if _, _, err := coll.Run(db, "up"); err == nil {
+		t.Fatal("expected up to fail")
+	}
+}
+
+func TestOutOfOrder(t *testing.T) {
+	db := connectDB()
+
+	coll := migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, Up: doNothing, Down: doNothing},
+		{Version: 3, Up: doNothing, Down: doNothing},
+	}...)
+	if _, _, err := coll.Run(db, "up"); err != nil {
+		t.Fatal(err)
+	}
+
+	var applied bool
+	coll = migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, Up: doPanic, Down: doPanic},
+		{Version: 2, Up: func(db migrations.DB) error {
+			applied = true
+			return nil
+		}, Down: doNothing},
+		{Version: 3, Up: doPanic, Down: doPanic},
+	}...)
+
+	pending, err := coll.Pending(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Version != 2 || !pending[0].OutOfOrder {
+		t.Fatalf("got %v, wanted out of order migration 2", pending)
+	}
+
+	if _, _, err := coll.Run(db, "up"); err != nil {
+		t.Fatal(err)
+	}
+	if applied {
+		t.Fatal("out of order migration is applied")
+	}
+
+	coll.AllowOutOfOrder(true)
+	oldVersion, newVersion, err := coll.Run(db, "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !applied {
+		t.Fatal("out of order migration is not applied")
+	}
+	if oldVersion != 3 || newVersion != 3 {
+		t.Fatalf("got %d -> %d, wanted 3 -> 3", oldVersion, newVersion)
+	}
+
+	pending, err = coll.Pending(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("got %d pending migrations, wanted 0", len(pending))
+	}
+}
+
+func TestPlan(t *testing.T) {
+	db := connectDB()
+
+	coll := migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, Up: doNothing, Down: doNothing},
+		{Version: 2, UpTx: true, Up: doPanic, Down: doPanic},
+		{Version: 3, Up: doPanic, Down: doPanic},
+	}...)
+	if _, _, err := coll.Run(db, "up", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := coll.Plan(db, "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 2 {
+		t.Fatalf("got %d migrations, wanted 2", len(plan))
+	}
+	if plan[0].Version != 2 || plan[0].Direction != "up" || !plan[0].Tx {
+		t.Fatalf("unexpected migration: %s", plan[0])
+	}
+
+	plan, err = coll.Plan(db, "reset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 1 || plan[0].Version != 1 || plan[0].Direction != "down" {
+		t.Fatalf("got %v, wanted down migration 1", plan)
+	}
+
+	if _, _, err := coll.Run(db, "plan"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	db := connectDB()
+
+	coll := migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, Name: "first", Up: doNothing, Down: doNothing},
+		{Version: 3, Name: "third", Up: doNothing, Down: doNothing},
+		{Version: 4, Name: "fourth", Up: doNothing, Down: doNothing},
+	}...)
+	if _, _, err := coll.Run(db, "up", "3"); err != nil {
+		t.Fatal(err)
+	}
+
+	coll = migrations.NewCollection([]*migrations.Migration{
+		{Version: 2, Name: "second", Up: doNothing, Down: doNothing},
+		{Version: 3, Name: "third", Up: doNothing, Down: doNothing},
+		{Version: 4, Name: "fourth", Up: doNothing, Down: doNothing},
+	}...)
+	status, err := coll.Status(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wanted := []migrations.MigrationState{
+		migrations.StateMissing,
+		migrations.StateIgnored,
+		migrations.StateApplied,
+		migrations.StatePending,
+	}
+	if len(status) != len(wanted) {
+		t.Fatalf("got %d migrations, wanted %d", len(status), len(wanted))
+	}
+	for i, st := range status {
+		if st.Version != int64(i+1) || st.State != wanted[i] {
+			t.Fatalf("got migration=%d %s, wanted migration=%d %s",
+				st.Version, st.State, i+1, wanted[i])
+		}
+	}
+	if status[0].Name != "first" || status[0].AppliedAt.IsZero() {
+		t.Fatalf("unexpected missing migration: %+v", status[0])
+	}
+}
+
+type recordingObserver struct {
+	events []string
+}
+
+func (o *recordingObserver) BeforeMigration(ctx context.Context, m *migrations.Migration, direction string) {
+	o.events = append(o.events, fmt.Sprintf("before %s %d", direction, m.Version))
+}
+
+func (o *recordingObserver) AfterMigration(ctx context.Context, m *migrations.ExecutedMigration) {
+	o.events = append(o.events, fmt.Sprintf("after %s %d", m.Direction, m.Version))
+}
+
+func (o *recordingObserver) OnLockWait(ctx context.Context, lock string) {}
+
+func (o *recordingObserver) OnError(
+	ctx context.Context, m *migrations.Migration, direction string, err error,
+) {
+	o.events = append(o.events, fmt.Sprintf("error %s %d", direction, m.Version))
+}
+
+func TestObserver(t *testing.T) {
+	db := connectDB()
+
+	o := new(recordingObserver)
+	coll := migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, Up: doNothing, Down: doNothing},
+		{Version: 2, Up: doFail, Down: doNothing},
+	}...).AddObserver(o)
+	_, _, err := coll.Run(db, "up")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	wanted := []string{"before up 1", "after up 1", "before up 2", "error up 2"}
+	if !reflect.DeepEqual(o.events, wanted) {
+		t.Fatalf("got %q, wanted %q", o.events, wanted)
+	}
+}
+
+func TestRunContextCancel(t *testing.T) {
+	db := connectDB()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	coll := migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, Up: func(db migrations.DB) error {
+			cancel()
+			return nil
+		}, Down: doNothing},
+		{Version: 2, Up: doPanic, Down: doPanic},
+	}...)
+	_, _, err := coll.RunContext(ctx, db, "up")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	version, err := coll.Version(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version > 1 {
+		t.Fatalf("got version %d, wanted at most 1", version)
+	}
+}
+
+func TestAdvisoryLocker(t *testing.T) {
+	db := connectDB()
+	ctx := context.Background()
+
+	unlock, err := migrations.NewAdvisoryLocker(42).Lock(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locker := migrations.NewAdvisoryLocker(42).SetTimeout(100 * time.Millisecond)
+	_, err = locker.Lock(ctx, db)
+	var lockErr *migrations.LockTimeoutError
+	if !errors.As(err, &lockErr) {
+		t.Fatalf("got %v, wanted LockTimeoutError", err)
+	}
+	if lockErr.PID == 0 {
+		t.Fatal("lock holder pid is unknown")
+	}
+
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	coll := migrations.NewCollection([]*migrations.Migration{
+		{Version: 1, Up: doNothing, Down: doNothing},
+	}...)
+	coll.SetLocker(locker)
+	_, newVersion, err := coll.Run(db, "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newVersion != 1 {
+		t.Fatalf("got version %d, wanted 1", newVersion)
+	}
+}
+
 func doNothing(db migrations.DB) error {
 	return nil
 }
 
+func doFail(db migrations.DB) error {
+	return errors.New("this migration fails")
+}
+
 func doPanic(db migrations.DB) error {
 	panic("this migration should not be run")
 }
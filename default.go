@@ -1,5 +1,7 @@
 package migrations
 
+import "context"
+
 var DefaultCollection = NewCollection()
 
 func SetTableName(name string) {
@@ -14,6 +16,27 @@ func SetVersion(db DB, version int64) error {
 	return DefaultCollection.SetVersion(db, version)
 }
 
+// Verify reports applied SQL migrations that were changed after
+// they had been applied.
+func Verify(db DB) ([]*ChecksumMismatch, error) {
+	return DefaultCollection.Verify(db)
+}
+
+// Pending returns migrations that are not applied yet.
+func Pending(db DB) ([]*PendingMigration, error) {
+	return DefaultCollection.Pending(db)
+}
+
+// Status returns migrations with their state.
+func Status(db DB) ([]*MigrationStatus, error) {
+	return DefaultCollection.Status(db)
+}
+
+// Plan returns migrations that would be run by the command.
+func Plan(db DB, a ...string) ([]*PlannedMigration, error) {
+	return DefaultCollection.Plan(db, a...)
+}
+
 // Register registers new database migration. Must be called
 // from file with name like "1_initialize_db.go", where:
 //   - 1 - migration version;
@@ -27,6 +50,16 @@ func RegisterTx(fns ...func(DB) error) error {
 	return DefaultCollection.RegisterTx(fns...)
 }
 
+// RegisterVersion registers migration with the explicit version and name.
+func RegisterVersion(version int64, name string, up, down func(DB) error) error {
+	return DefaultCollection.RegisterVersion(version, name, up, down)
+}
+
+// RegisterVersionTx is just like RegisterVersion but marks the migration to be executed inside a transaction.
+func RegisterVersionTx(version int64, name string, up, down func(DB) error) error {
+	return DefaultCollection.RegisterVersionTx(version, name, up, down)
+}
+
 func MustRegister(fns ...func(DB) error) {
 	DefaultCollection.MustRegister(fns...)
 }
@@ -42,10 +75,30 @@ func RegisteredMigrations() []*Migration {
 
 // Run runs command on the db. Supported commands are:
 // - up [target] - runs all available migrations by default or up to target one if argument is provided.
-// - down - reverts last migration.
+// - up +N - runs next N available migrations.
+// - up --atomic - runs migrations in a single transaction.
+// - down [N] - reverts last migration or last N migrations.
+// - down-to version - reverts migrations with versions higher than the version.
+// - goto version - runs or reverts migrations to reach the version.
 // - reset - reverts all migrations.
+// - redo [N] - reverts and runs again last migration or last N migrations.
 // - version - prints current db version.
+// - pending - prints migrations that are not applied yet.
+// - status - prints migrations with their state.
+// - plan [command] - prints migrations that up, down, down-to, goto or reset would run.
+// - verify - checks that applied SQL migrations were not changed.
 // - set_version - sets db version without running migrations.
+// - force - sets db version and clears the dirty state.
 func Run(db DB, a ...string) (oldVersion, newVersion int64, err error) {
 	return DefaultCollection.Run(db, a...)
 }
+
+// RunContext is like Run, but cancelling the ctx aborts migrations.
+func RunContext(ctx context.Context, db DB, a ...string) (oldVersion, newVersion int64, err error) {
+	return DefaultCollection.RunContext(ctx, db, a...)
+}
+
+// Exec runs the command like RunContext and returns the result instead of printing it.
+func Exec(ctx context.Context, db DB, cmd Command) (*RunResult, error) {
+	return DefaultCollection.Exec(ctx, db, cmd)
+}
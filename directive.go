@@ -0,0 +1,210 @@
+package migrations
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+const directivePrefix = "--gopg:"
+
+// sqlDirectives are the --gopg: directives from the header
+// of an SQL migration file, e.g.
+//
+//	--gopg:notx
+//	--gopg:lock_timeout 2s
+//	--gopg:retry 5
+type sqlDirectives struct {
+	tx               bool
+	notx             bool
+	statementTimeout time.Duration
+	lockTimeout      time.Duration
+	isolation        string
+	description      string
+	irreversible     bool
+	retry            int
+}
+
+func parseSQLDirectives(b []byte) (*sqlDirectives, error) {
+	d := new(sqlDirectives)
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	header := true
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+
+		name, value, ok := cutDirective(s)
+		if !ok {
+			if s != "" && !strings.HasPrefix(s, "--") {
+				header = false
+			}
+			continue
+		}
+
+		if name == "split" {
+			continue
+		}
+		if !header {
+			return nil, fmt.Errorf(
+				"gopg directive %q at line %d must be in the file header", name, line)
+		}
+		if err := d.set(name, value); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if d.tx && d.notx {
+		return nil, fmt.Errorf("gopg directives tx and notx can't be used together")
+	}
+	if d.isolation != "" && d.notx {
+		return nil, fmt.Errorf("gopg directive isolation requires a transaction")
+	}
+	if d.retry > 0 && d.notx {
+		return nil, fmt.Errorf("gopg directive retry requires a transaction")
+	}
+
+	return d, nil
+}
+
+// cutDirective parses a line like "--gopg:lock_timeout 2s".
+func cutDirective(s string) (name, value string, ok bool) {
+	if !strings.HasPrefix(s, directivePrefix) {
+		return "", "", false
+	}
+	s = strings.TrimSpace(s[len(directivePrefix):])
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i+1:]), true
+	}
+	return s, "", true
+}
+
+func (d *sqlDirectives) set(name, value string) error {
+	var err error
+	switch name {
+	case "tx":
+		d.tx = true
+	case "notx":
+		d.notx = true
+	case "statement_timeout":
+		d.statementTimeout, err = time.ParseDuration(value)
+	case "lock_timeout":
+		d.lockTimeout, err = time.ParseDuration(value)
+	case "isolation":
+		switch v := strings.ToLower(value); v {
+		case "serializable", "repeatable read", "read committed", "read uncommitted":
+			d.isolation = v
+		default:
+			err = fmt.Errorf("unsupported isolation level: %q", value)
+		}
+	case "description":
+		d.description = value
+	case "irreversible":
+		d.irreversible = true
+	case "retry":
+		d.retry, err = strconv.Atoi(value)
+		if err == nil && d.retry < 1 {
+			err = fmt.Errorf("number of attempts must be positive: %q", value)
+		}
+	default:
+		return fmt.Errorf("unknown gopg directive: %q", name)
+	}
+	if err != nil {
+		return fmt.Errorf("gopg directive %s: %w", name, err)
+	}
+	return nil
+}
+
+// retryPolicy returns the policy set by the retry directive. The lock
+// timeout is set by the lock_timeout directive and backoff uses defaults.
+func (d *sqlDirectives) retryPolicy() *RetryPolicy {
+	return &RetryPolicy{MaxAttempts: d.retry}
+}
+
+// useTx reports whether the migration must be run in a transaction.
+// Directives override the .tx.up.sql and .tx.down.sql extensions
+// and the isolation directive implies a transaction.
+func (d *sqlDirectives) useTx(ext bool) bool {
+	switch {
+	case d.tx, d.isolation != "":
+		return true
+	case d.notx:
+		return false
+	default:
+		return ext
+	}
+}
+
+type sqlSetting struct {
+	name  string
+	value string
+}
+
+func (d *sqlDirectives) settings() []sqlSetting {
+	var settings []sqlSetting
+	if d.statementTimeout > 0 {
+		settings = append(settings, sqlSetting{
+			name:  "statement_timeout",
+			value: strconv.FormatInt(d.statementTimeout.Milliseconds(), 10),
+		})
+	}
+	if d.lockTimeout > 0 {
+		settings = append(settings, sqlSetting{
+			name:  "lock_timeout",
+			value: strconv.FormatInt(d.lockTimeout.Milliseconds(), 10),
+		})
+	}
+	return settings
+}
+
+// applySQLSettings sets the settings for the transaction or, outside
+// of transactions, for the session. The returned function resets
+// the session settings and restores the previous transaction settings,
+// because the transaction may run other migrations, e.g. with up --atomic.
+func applySQLSettings(db DB, settings []sqlSetting) (func(), error) {
+	_, isTx := db.(*pg.Tx)
+
+	var prev []string
+	reset := func() {
+		if isTx {
+			for i, value := range prev {
+				_, _ = db.Exec("SELECT set_config(?, ?, true)", settings[i].name, value)
+			}
+			return
+		}
+		for _, s := range settings {
+			_, _ = db.Exec("RESET ?", pg.SafeQuery(s.name))
+		}
+	}
+
+	for _, s := range settings {
+		if isTx {
+			var value string
+			_, err := db.QueryOne(pg.Scan(&value), "SELECT current_setting(?)", s.name)
+			if err != nil {
+				reset()
+				return nil, err
+			}
+			prev = append(prev, value)
+		}
+
+		q := "SET ? = ?"
+		if isTx {
+			q = "SET LOCAL ? = ?"
+		}
+		_, err := db.Exec(q, pg.SafeQuery(s.name), pg.SafeQuery(s.value))
+		if err != nil {
+			reset()
+			return nil, err
+		}
+	}
+
+	return reset, nil
+}
@@ -0,0 +1,123 @@
+package migrations
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestParseSQLDirectives(t *testing.T) {
+	sql := `-- Adds index on users.email.
+--gopg:notx
+--gopg:statement_timeout 5m
+--gopg:lock_timeout 2s
+--gopg:description add index on users email
+
+CREATE INDEX CONCURRENTLY users_email_idx ON users (email);
+--gopg:split
+SELECT 1;
+`
+	d, err := parseSQLDirectives([]byte(sql))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wanted := &sqlDirectives{
+		notx:             true,
+		statementTimeout: 5 * time.Minute,
+		lockTimeout:      2 * time.Second,
+		description:      "add index on users email",
+	}
+	if !reflect.DeepEqual(d, wanted) {
+		t.Fatalf("got %+v, wanted %+v", d, wanted)
+	}
+
+	settings := []sqlSetting{
+		{name: "statement_timeout", value: "300000"},
+		{name: "lock_timeout", value: "2000"},
+	}
+	if !reflect.DeepEqual(d.settings(), settings) {
+		t.Fatalf("got %+v, wanted %+v", d.settings(), settings)
+	}
+}
+
+func TestParseSQLDirectivesErrors(t *testing.T) {
+	tests := []string{
+		"--gopg:unknown\nSELECT 1",
+		"--gopg:lock_timeout soon\nSELECT 1",
+		"--gopg:isolation snapshot\nSELECT 1",
+		"--gopg:notx\n--gopg:isolation serializable\nSELECT 1",
+		"--gopg:tx\n--gopg:notx\nSELECT 1",
+		"--gopg:retry 0\nSELECT 1",
+		"--gopg:notx\n--gopg:retry 3\nSELECT 1",
+		"SELECT 1;\n--gopg:notx",
+	}
+	for _, sql := range tests {
+		if _, err := parseSQLDirectives([]byte(sql)); err == nil {
+			t.Errorf("parseSQLDirectives(%q) succeeded, wanted an error", sql)
+		}
+	}
+}
+
+func TestDiscoverSQLDirectives(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"1_initial.tx.up.sql": "--gopg:notx\n--gopg:description creates users\nCREATE TABLE users ()",
+		"1_initial.down.sql":  "--gopg:isolation serializable\nDROP TABLE users",
+	}
+	for name, sql := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(sql), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	coll := NewCollection()
+	coll.DisableSQLAutodiscover(true)
+	if err := coll.DiscoverSQLMigrations(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	ms := coll.Migrations()
+	if len(ms) != 1 {
+		t.Fatalf("got %d migrations, wanted 1", len(ms))
+	}
+	m := ms[0]
+	if m.UpTx || !m.DownTx {
+		t.Fatalf("got UpTx=%v DownTx=%v, wanted false and true", m.UpTx, m.DownTx)
+	}
+	if m.Description != "creates users" {
+		t.Fatalf("got description %q", m.Description)
+	}
+	if m.downIsolation != "serializable" {
+		t.Fatalf("got isolation %q, wanted serializable", m.downIsolation)
+	}
+}
+
+func TestDiscoverRetryDirective(t *testing.T) {
+	coll := NewCollection()
+	coll.DisableSQLAutodiscover(true)
+	err := coll.DiscoverSQLMigrationsFS(fstest.MapFS{
+		"migrations/1_index.tx.up.sql":   {Data: []byte("--gopg:retry 3\nCREATE INDEX idx ON users (email)")},
+		"migrations/1_index.tx.down.sql": {Data: []byte("--gopg:retry 5\nDROP INDEX idx")},
+	}, "migrations", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := coll.Migrations()
+	if len(ms) != 1 || ms[0].Retry == nil || ms[0].Retry.MaxAttempts != 3 {
+		t.Fatalf("got %+v, wanted retry policy with 3 attempts", ms[0])
+	}
+
+	coll = NewCollection()
+	coll.DisableSQLAutodiscover(true)
+	err = coll.DiscoverSQLMigrationsFS(fstest.MapFS{
+		"migrations/1_index.up.sql": {Data: []byte("--gopg:retry 3\nCREATE INDEX idx ON users (email)")},
+	}, "migrations", nil)
+	wanted := `file="migrations/1_index.up.sql": gopg directive retry requires a transaction`
+	if err == nil || err.Error() != wanted {
+		t.Fatalf("got %v, wanted %s", err, wanted)
+	}
+}
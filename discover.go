@@ -0,0 +1,156 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"reflect"
+	"strings"
+)
+
+// DiscoverOptions configures DiscoverSQLMigrationsFS.
+type DiscoverOptions struct {
+	// Include and Exclude are path.Match patterns, e.g. "2024/*.sql".
+	// Patterns are matched against file paths relative to the scanned dir
+	// and patterns without a slash are matched against file names.
+	// When Include is empty, all files are included.
+	Include []string
+	Exclude []string
+}
+
+func (opt *DiscoverOptions) validate() error {
+	if opt == nil {
+		return nil
+	}
+	for _, patterns := range [][]string{opt.Include, opt.Exclude} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("pattern=%q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (opt *DiscoverOptions) match(rel string) bool {
+	if opt == nil {
+		return true
+	}
+	if len(opt.Include) > 0 && !matchAny(opt.Include, rel) {
+		return false
+	}
+	return !matchAny(opt.Exclude, rel)
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DiscoverSQLMigrationsFS scans the dir from the given filesystem and its
+// subdirectories for files with .sql extension and adds discovered SQL
+// migrations to the collection. Files in subdirectories are ordered by their
+// versions like the other migrations, e.g. migrations/2024/1_initial.up.sql.
+// Files discovered by previous calls are skipped. opts may be nil.
+func (c *Collection) DiscoverSQLMigrationsFS(fsys fs.FS, dir string, opts *DiscoverOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	var paths []string
+	err := fs.WalkDir(fsys, dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if filePath == dir && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel := strings.TrimPrefix(filePath, dir+"/")
+		if dir == "." {
+			rel = filePath
+		}
+		if opts.match(rel) {
+			paths = append(paths, filePath)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	key := fsKey(fsys)
+	paths = c.unvisitedFiles(key, paths)
+	if err := c.addSQLMigrations(http.FS(fsys), paths); err != nil {
+		return err
+	}
+	c.visitFiles(key, paths)
+	return nil
+}
+
+// fsFile identifies a file of the filesystem.
+type fsFile struct {
+	fsys any
+	path string
+}
+
+// fsKey returns a comparable key of the filesystem or nil when the
+// filesystem can't be compared. Maps like fstest.MapFS are compared
+// by identity.
+func fsKey(fsys fs.FS) any {
+	v := reflect.ValueOf(fsys)
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Func:
+		return [2]any{v.Type(), v.Pointer()}
+	}
+	if !v.Comparable() {
+		return nil
+	}
+	return fsys
+}
+
+func (c *Collection) unvisitedFiles(key any, paths []string) []string {
+	if key == nil {
+		return paths
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unvisited := paths[:0:0]
+	for _, p := range paths {
+		if _, ok := c.visitedFiles[fsFile{key, p}]; !ok {
+			unvisited = append(unvisited, p)
+		}
+	}
+	return unvisited
+}
+
+func (c *Collection) visitFiles(key any, paths []string) {
+	if key == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.visitedFiles == nil {
+		c.visitedFiles = make(map[fsFile]struct{})
+	}
+	for _, p := range paths {
+		c.visitedFiles[fsFile{key, p}] = struct{}{}
+	}
+}
@@ -0,0 +1,218 @@
+package migrations
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDiscoverSQLMigrationsFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/2023/1_initial.up.sql":   {Data: []byte("CREATE TABLE users ()")},
+		"migrations/2023/1_initial.down.sql": {Data: []byte("DROP TABLE users")},
+		"migrations/2024/2_add_email.up.sql": {Data: []byte("ALTER TABLE users ADD email text")},
+		"migrations/2024/3_seed.up.sql":      {Data: []byte("INSERT INTO users DEFAULT VALUES")},
+		"migrations/2024/README.md":          {Data: []byte("not a migration")},
+		"migrations/archive/0_legacy.up.sql": {Data: []byte("SELECT 1")},
+		"other/100_not_in_migrations.up.sql": {Data: []byte("SELECT 1")},
+	}
+
+	coll := NewCollection()
+	coll.DisableSQLAutodiscover(true)
+	err := coll.DiscoverSQLMigrationsFS(fsys, "migrations", &DiscoverOptions{
+		Exclude: []string{"archive/*", "3_*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ms := coll.Migrations()
+	if len(ms) != 2 {
+		t.Fatalf("got %d migrations, wanted 2", len(ms))
+	}
+	if ms[0].Version != 1 || ms[0].Down == nil || ms[0].upFile != "migrations/2023/1_initial.up.sql" {
+		t.Fatalf("got %+v", ms[0])
+	}
+	if ms[1].Version != 2 || ms[1].Name != "add_email" {
+		t.Fatalf("got %+v", ms[1])
+	}
+
+	coll = NewCollection()
+	coll.DisableSQLAutodiscover(true)
+	err = coll.DiscoverSQLMigrationsFS(fsys, "migrations", &DiscoverOptions{
+		Include: []string{"2024/*.sql"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms := coll.Migrations(); len(ms) != 2 || ms[0].Version != 2 || ms[1].Version != 3 {
+		t.Fatalf("got %d migrations, wanted versions 2 and 3", len(ms))
+	}
+
+	if err := coll.DiscoverSQLMigrationsFS(fsys, "not-existing-dir", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiscoverSQLMigrationsFSTwice(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1_initial.up.sql":   {Data: []byte("CREATE TABLE users ()")},
+		"migrations/2_add_email.up.sql": {Data: []byte("ALTER TABLE users ADD email text")},
+	}
+
+	coll := NewCollection()
+	coll.DisableSQLAutodiscover(true)
+	for i := 0; i < 2; i++ {
+		err := coll.DiscoverSQLMigrationsFS(fsys, "migrations", &DiscoverOptions{
+			Include: []string{"1_*"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := coll.DiscoverSQLMigrationsFS(fsys, "migrations", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ms := coll.Migrations()
+	if len(ms) != 2 {
+		t.Fatalf("got %d migrations, wanted 2", len(ms))
+	}
+	if err := validateMigrations(ms); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiscoverIrreversible(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1_initial.up.sql":     {Data: []byte("CREATE TABLE users ()")},
+		"migrations/2_drop_legacy.up.sql": {Data: []byte("--gopg:irreversible\nDROP TABLE legacy")},
+	}
+
+	coll := NewCollection()
+	coll.DisableSQLAutodiscover(true)
+	if err := coll.DiscoverSQLMigrationsFS(fsys, "migrations", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ms := coll.Migrations()
+	if len(ms) != 2 || ms[0].Irreversible || !ms[1].Irreversible {
+		t.Fatalf("got %+v", ms)
+	}
+
+	if err := coll.checkReversible(ms[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := coll.checkReversible(ms[1]); !errors.Is(err, ErrIrreversible) {
+		t.Fatalf("got %v, wanted ErrIrreversible", err)
+	}
+
+	coll.RequireDown(true)
+	if err := coll.checkReversible(ms[0]); !errors.Is(err, ErrIrreversible) {
+		t.Fatalf("got %v, wanted ErrIrreversible", err)
+	}
+}
+
+func TestDiscoverSQLMigrationsFSErrors(t *testing.T) {
+	tests := []struct {
+		fsys   fstest.MapFS
+		wanted string
+	}{{
+		fsys: fstest.MapFS{
+			"migrations/a/1_initial.up.sql": {Data: []byte("SELECT 1")},
+			"migrations/b/1_other.up.sql":   {Data: []byte("SELECT 2")},
+		},
+		wanted: `file="migrations/b/1_other.up.sql": migration=1 already has Up func`,
+	}, {
+		fsys: fstest.MapFS{
+			"migrations/2024/initial.up.sql": {Data: []byte("SELECT 1")},
+		},
+		wanted: `file="migrations/2024/initial.up.sql" must have name in format version_comment`,
+	}, {
+		fsys: fstest.MapFS{
+			"migrations/2024/1_initial.sql": {Data: []byte("SELECT 1")},
+		},
+		wanted: `file="migrations/2024/1_initial.sql" must have extension .up.sql or .down.sql`,
+	}, {
+		fsys: fstest.MapFS{
+			"migrations/1_initial.up.sql":   {Data: []byte("--gopg:irreversible\nSELECT 1")},
+			"migrations/1_initial.down.sql": {Data: []byte("SELECT 2")},
+		},
+		wanted: `file="migrations/1_initial.down.sql": migration=1 is irreversible, but has down file`,
+	}, {
+		fsys: fstest.MapFS{
+			"migrations/1_initial.down.sql": {Data: []byte("--gopg:irreversible\nSELECT 1")},
+		},
+		wanted: `file="migrations/1_initial.down.sql": gopg directive irreversible must be in the up file`,
+	}}
+	for _, test := range tests {
+		coll := NewCollection()
+		coll.DisableSQLAutodiscover(true)
+		err := coll.DiscoverSQLMigrationsFS(test.fsys, "migrations", nil)
+		if err == nil || !strings.HasPrefix(err.Error(), test.wanted) {
+			t.Errorf("got %v, wanted %s", err, test.wanted)
+		}
+	}
+
+	coll := NewCollection()
+	err := coll.DiscoverSQLMigrationsFS(fstest.MapFS{}, ".", &DiscoverOptions{Include: []string{"["}})
+	if err == nil {
+		t.Fatal("bad pattern is accepted")
+	}
+}
+
+func TestAutodiscoverErrors(t *testing.T) {
+	writeFiles := func(files map[string]string) string {
+		dir := t.TempDir()
+		for name, sql := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(sql), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dir
+	}
+
+	// Files that don't look like migrations are skipped.
+	dir := writeFiles(map[string]string{
+		"schema.sql":       "CREATE TABLE users ()",
+		"seed.sql":         "INSERT INTO users DEFAULT VALUES",
+		"1_initial.up.sql": "CREATE TABLE users ()",
+	})
+	coll := NewCollection()
+	if err := coll.discoverDir(osfilesystem{}, dir, true); err != nil {
+		t.Fatal(err)
+	}
+	if ms := coll.Migrations(); len(ms) != 1 {
+		t.Fatalf("got %d migrations, wanted 1", len(ms))
+	}
+
+	// Errors are reported every time the dir is discovered.
+	dir = writeFiles(map[string]string{
+		"1_initial.up.sql": "--gopg:lock_timeout 2\nSELECT 1",
+	})
+	coll = NewCollection()
+	for i := 0; i < 2; i++ {
+		err := coll.discoverDir(osfilesystem{}, dir, true)
+		if err == nil || !strings.Contains(err.Error(), "1_initial.up.sql") {
+			t.Fatalf("got %v, wanted discovery error", err)
+		}
+	}
+
+	// Errors in the working dir are ignored.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	coll = NewCollection()
+	if _, err := coll.discoverMigrations(); err != nil {
+		t.Fatal(err)
+	}
+}
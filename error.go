@@ -0,0 +1,129 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// ErrIrreversible is returned by down commands that reach
+// an irreversible migration.
+var ErrIrreversible = errors.New("migration is irreversible")
+
+// ErrDirty is returned by commands that run migrations when
+// a non-transactional migration failed or was interrupted.
+var ErrDirty = errors.New("database is dirty")
+
+// MigrationError is returned when an SQL migration fails.
+type MigrationError struct {
+	Version   int64
+	Direction string
+	File      string
+	// Statement is the failing SQL statement and StatementIndex
+	// is its number in the file, starting from 1.
+	Statement      string
+	StatementIndex int
+	// Line and Column of the error in the file, starting from 1.
+	// Column is zero when PostgreSQL does not report the error position.
+	Line   int
+	Column int
+
+	Err error
+
+	// position is the rune offset of the error in the statement, starting from 1.
+	position int
+}
+
+func (e *MigrationError) Error() string {
+	loc := e.File
+	if e.Line > 0 {
+		loc += ":" + strconv.Itoa(e.Line)
+		if e.Column > 0 {
+			loc += ":" + strconv.Itoa(e.Column)
+		}
+	}
+	if e.StatementIndex > 0 {
+		loc += " (statement #" + strconv.Itoa(e.StatementIndex) + ")"
+	}
+	return fmt.Sprintf("migration=%d %s failed at %s: %s", e.Version, e.Direction, loc, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// Excerpt returns the failing line of the statement prefixed with the line
+// number and followed by a caret pointing at the error position, e.g.
+//
+//	3 | SELECT * FROM usr;
+//	  |               ^
+//
+// It returns an empty string when the position is unknown.
+func (e *MigrationError) Excerpt() string {
+	if e.position <= 0 {
+		return ""
+	}
+
+	runes := []rune(e.Statement)
+	if e.position > len(runes) {
+		return ""
+	}
+
+	lineStart := 0
+	for i := 0; i < e.position-1; i++ {
+		if runes[i] == '\n' {
+			lineStart = i + 1
+		}
+	}
+	lineEnd := lineStart
+	for lineEnd < len(runes) && runes[lineEnd] != '\n' {
+		lineEnd++
+	}
+
+	prefix := strconv.Itoa(e.Line) + " | "
+	gutter := strings.Repeat(" ", len(prefix)-2) + "| "
+	caret := strings.Repeat(" ", e.position-1-lineStart) + "^"
+	return prefix + string(runes[lineStart:lineEnd]) + "\n" + gutter + caret
+}
+
+func newMigrationError(
+	version int64, direction, file string, index int, q sqlQuery, err error,
+) *MigrationError {
+	merr := &MigrationError{
+		Version:        version,
+		Direction:      direction,
+		File:           file,
+		Statement:      q.query,
+		StatementIndex: index,
+		Line:           q.line,
+		Err:            err,
+	}
+
+	pgErr, ok := err.(pg.Error)
+	if !ok {
+		return merr
+	}
+	pos, _ := strconv.Atoi(pgErr.Field('P'))
+	runes := []rune(q.query)
+	if pos <= 0 || pos > len(runes) {
+		return merr
+	}
+
+	merr.position = pos
+	lineStart := 0
+	for i, r := range runes[:pos-1] {
+		if r == '\n' {
+			merr.Line++
+			lineStart = i + 1
+		}
+	}
+	merr.Column = pos - lineStart
+	if merr.Line == q.line {
+		merr.Column += q.column - 1
+	}
+
+	return merr
+}
@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+)
+
+type testPGError map[byte]string
+
+func (e testPGError) Error() string            { return e['M'] }
+func (e testPGError) Field(k byte) string      { return e[k] }
+func (e testPGError) IntegrityViolation() bool { return false }
+
+func TestMigrationError(t *testing.T) {
+	pgErr := testPGError{'M': `relation "usr" does not exist`, 'P': "25"}
+	q := sqlQuery{query: "SELECT 1;\nSELECT * FROM usr", line: 3, column: 5}
+
+	err := newMigrationError(12, "up", "12_users.up.sql", 2, q, pgErr)
+	if err.Line != 4 || err.Column != 15 {
+		t.Fatalf("got %d:%d, wanted 4:15", err.Line, err.Column)
+	}
+	var target pg.Error
+	if !errors.As(err, &target) {
+		t.Fatal("MigrationError does not wrap the PostgreSQL error")
+	}
+
+	wanted := `migration=12 up failed at 12_users.up.sql:4:15 (statement #2): relation "usr" does not exist`
+	if err.Error() != wanted {
+		t.Fatalf("got %q, wanted %q", err.Error(), wanted)
+	}
+
+	wanted = "4 | SELECT * FROM usr\n  |               ^"
+	if err.Excerpt() != wanted {
+		t.Fatalf("got excerpt:\n%s\nwanted:\n%s", err.Excerpt(), wanted)
+	}
+}
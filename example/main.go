@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 
 	"github.com/go-pg/migrations/v8"
 	"github.com/go-pg/pg/v10"
@@ -13,10 +16,20 @@ const usageText = `This program runs command on the db. Supported commands are:
   - init - creates version info table in the database
   - up - runs all available migrations.
   - up [target] - runs available migrations up to the target one.
-  - down - reverts last migration.
+  - up +N - runs next N available migrations.
+  - up --atomic - runs migrations in a single transaction.
+  - down [N] - reverts last migration or last N migrations.
+  - down-to [version] - reverts migrations with versions higher than the version.
+  - goto [version] - runs or reverts migrations to reach the version.
   - reset - reverts all migrations.
+  - redo [N] - reverts and runs again last migration or last N migrations.
   - version - prints current db version.
+  - verify - checks that applied SQL migrations were not changed.
+  - pending - prints migrations that are not applied yet.
+  - status - prints migrations with their state.
+  - plan [command] - prints migrations that up, down, down-to, goto or reset would run.
   - set_version [version] - sets db version without running migrations.
+  - force [version] - sets db version and clears the dirty state.
 
 Usage:
   go run *.go <command> [args]
@@ -31,8 +44,17 @@ func main() {
 		Database: "pg_migrations_example",
 	})
 
-	oldVersion, newVersion, err := migrations.Run(db, flag.Args()...)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	oldVersion, newVersion, err := migrations.RunContext(ctx, db, flag.Args()...)
 	if err != nil {
+		var merr *migrations.MigrationError
+		if errors.As(err, &merr) {
+			if excerpt := merr.Excerpt(); excerpt != "" {
+				errorf(excerpt)
+			}
+		}
 		exitf(err.Error())
 	}
 	if newVersion != oldVersion {
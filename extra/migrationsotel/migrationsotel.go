@@ -0,0 +1,237 @@
+// Package migrationsotel traces migration runs using OpenTelemetry.
+package migrationsotel
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/go-pg/migrations/v8"
+)
+
+const instrumentationName = "github.com/go-pg/migrations/extra/migrationsotel"
+
+// Option configures the instrumentation.
+type Option func(c *config)
+
+type config struct {
+	provider trace.TracerProvider
+}
+
+// WithTracerProvider sets the tracer provider.
+// The global provider is used by default.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.provider = provider
+	}
+}
+
+// Collection is a migrations.Collection that creates a span for every run
+// and child spans for every migration with events for SQL statements.
+type Collection struct {
+	*migrations.Collection
+	tracer trace.Tracer
+}
+
+// Instrument adds the tracing observer to the collection and returns
+// the collection that traces Run, RunContext and Exec.
+func Instrument(coll *migrations.Collection, opts ...Option) *Collection {
+	cfg := &config{
+		provider: otel.GetTracerProvider(),
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	tracer := cfg.provider.Tracer(instrumentationName)
+	coll.AddObserver(newObserver(tracer))
+
+	return &Collection{
+		Collection: coll,
+		tracer:     tracer,
+	}
+}
+
+// Run is like migrations.Collection.Run, but traces the run.
+func (c *Collection) Run(db migrations.DB, a ...string) (oldVersion, newVersion int64, err error) {
+	return c.RunContext(db.Context(), db, a...)
+}
+
+// RunContext is like migrations.Collection.RunContext, but traces the run.
+func (c *Collection) RunContext(
+	ctx context.Context, db migrations.DB, a ...string,
+) (oldVersion, newVersion int64, err error) {
+	ctx, span := c.start(ctx, migrations.ParseCommand(a...))
+	defer span.End()
+
+	oldVersion, newVersion, err = c.Collection.RunContext(ctx, db, a...)
+	endRun(span, oldVersion, newVersion, err)
+	return oldVersion, newVersion, err
+}
+
+// Exec is like migrations.Collection.Exec, but traces the run.
+func (c *Collection) Exec(
+	ctx context.Context, db migrations.DB, cmd migrations.Command,
+) (*migrations.RunResult, error) {
+	ctx, span := c.start(ctx, cmd)
+	defer span.End()
+
+	res, err := c.Collection.Exec(ctx, db, cmd)
+	endRun(span, res.OldVersion, res.NewVersion, err)
+	return res, err
+}
+
+func (c *Collection) start(ctx context.Context, cmd migrations.Command) (context.Context, trace.Span) {
+	return c.tracer.Start(ctx, "migrations.run", trace.WithAttributes(
+		attribute.String("migrations.command", cmd.String()),
+	))
+}
+
+func endRun(span trace.Span, oldVersion, newVersion int64, err error) {
+	span.SetAttributes(
+		attribute.Int64("migrations.old_version", oldVersion),
+		attribute.Int64("migrations.new_version", newVersion),
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// observer creates spans for migrations. Migrations run in a single
+// transaction, e.g. by up --atomic, are reported after the commit, so
+// spans are tracked per run span, version and direction.
+type observer struct {
+	tracer trace.Tracer
+
+	mu    sync.Mutex
+	spans map[spanKey]trace.Span
+	// current is the last started migration span of the run span.
+	current map[trace.SpanID]trace.Span
+}
+
+type spanKey struct {
+	run       trace.SpanID
+	version   int64
+	direction string
+}
+
+var (
+	_ migrations.Observer          = (*observer)(nil)
+	_ migrations.StatementObserver = (*observer)(nil)
+	_ migrations.RunObserver       = (*observer)(nil)
+)
+
+func newObserver(tracer trace.Tracer) *observer {
+	return &observer{
+		tracer:  tracer,
+		spans:   make(map[spanKey]trace.Span),
+		current: make(map[trace.SpanID]trace.Span),
+	}
+}
+
+func (o *observer) BeforeMigration(ctx context.Context, m *migrations.Migration, direction string) {
+	tx := m.UpTx
+	if direction == "down" {
+		tx = m.DownTx
+	}
+
+	_, span := o.tracer.Start(ctx, "migrations."+direction, trace.WithAttributes(
+		attribute.Int64("migrations.version", m.Version),
+		attribute.String("migrations.name", m.Name),
+		attribute.String("migrations.direction", direction),
+		attribute.Bool("migrations.tx", tx),
+	))
+
+	o.mu.Lock()
+	o.spans[newSpanKey(ctx, m.Version, direction)] = span
+	o.current[runSpanID(ctx)] = span
+	o.mu.Unlock()
+}
+
+func (o *observer) AfterMigration(ctx context.Context, m *migrations.ExecutedMigration) {
+	if span := o.pop(newSpanKey(ctx, m.Version, m.Direction)); span != nil {
+		span.End()
+	}
+}
+
+func (o *observer) OnLockWait(ctx context.Context, lock string) {
+	trace.SpanFromContext(ctx).AddEvent("waiting for lock", trace.WithAttributes(
+		attribute.String("migrations.lock", lock),
+	))
+}
+
+func (o *observer) OnError(ctx context.Context, m *migrations.Migration, direction string, err error) {
+	span := trace.SpanFromContext(ctx)
+	if m != nil {
+		span = o.pop(newSpanKey(ctx, m.Version, direction))
+		if span == nil {
+			return
+		}
+		defer span.End()
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+func (o *observer) OnStatement(ctx context.Context, file string, line int, query string) {
+	o.mu.Lock()
+	span := o.current[runSpanID(ctx)]
+	o.mu.Unlock()
+
+	if span == nil {
+		return
+	}
+	span.AddEvent("statement", trace.WithAttributes(
+		attribute.String("db.statement", query),
+		attribute.String("code.filepath", file),
+		attribute.Int("code.lineno", line),
+	))
+}
+
+// AfterRun ends spans of migrations that were rolled back
+// with the transaction of the run.
+func (o *observer) AfterRun(ctx context.Context, res *migrations.RunResult, err error) {
+	id := runSpanID(ctx)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for key, span := range o.spans {
+		if key.run != id {
+			continue
+		}
+		span.SetStatus(codes.Error, "rolled back")
+		span.End()
+		delete(o.spans, key)
+	}
+	delete(o.current, id)
+}
+
+func (o *observer) pop(key spanKey) trace.Span {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	span := o.spans[key]
+	delete(o.spans, key)
+	if o.current[key.run] == span {
+		delete(o.current, key.run)
+	}
+	return span
+}
+
+func newSpanKey(ctx context.Context, version int64, direction string) spanKey {
+	return spanKey{
+		run:       runSpanID(ctx),
+		version:   version,
+		direction: direction,
+	}
+}
+
+func runSpanID(ctx context.Context) trace.SpanID {
+	return trace.SpanContextFromContext(ctx).SpanID()
+}
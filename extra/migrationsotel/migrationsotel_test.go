@@ -0,0 +1,117 @@
+package migrationsotel
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/go-pg/migrations/v8"
+)
+
+func TestObserver(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer := provider.Tracer(instrumentationName)
+	o := newObserver(tracer)
+
+	ctx, runSpan := tracer.Start(context.Background(), "migrations.run")
+
+	m1 := &migrations.Migration{Version: 1, Name: "initial", UpTx: true}
+	o.BeforeMigration(ctx, m1, "up")
+	o.OnStatement(ctx, "1_initial.tx.up.sql", 3, "CREATE TABLE users ()")
+	o.AfterMigration(ctx, &migrations.ExecutedMigration{Migration: m1, Direction: "up"})
+
+	m2 := &migrations.Migration{Version: 2, Name: "broken"}
+	o.BeforeMigration(ctx, m2, "up")
+	o.OnError(ctx, m2, "up", errors.New("boom"))
+
+	runSpan.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, wanted 3", len(spans))
+	}
+
+	first, second, run := spans[0], spans[1], spans[2]
+	if first.Parent.SpanID() != run.SpanContext.SpanID() {
+		t.Fatal("migration span is not a child of the run span")
+	}
+	wanted := map[attribute.Key]attribute.Value{
+		"migrations.version":   attribute.Int64Value(1),
+		"migrations.name":      attribute.StringValue("initial"),
+		"migrations.direction": attribute.StringValue("up"),
+		"migrations.tx":        attribute.BoolValue(true),
+	}
+	for _, kv := range first.Attributes {
+		if v, ok := wanted[kv.Key]; ok && v != kv.Value {
+			t.Fatalf("got %s=%v, wanted %v", kv.Key, kv.Value.Emit(), v.Emit())
+		}
+	}
+	if len(first.Events) != 1 || first.Events[0].Name != "statement" {
+		t.Fatalf("got events %+v, wanted a statement event", first.Events)
+	}
+
+	if second.Status.Code != codes.Error {
+		t.Fatalf("got status %v, wanted error", second.Status.Code)
+	}
+	if len(o.spans) != 0 {
+		t.Fatalf("got %d unfinished spans", len(o.spans))
+	}
+}
+
+func TestObserverSingleTx(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer := provider.Tracer(instrumentationName)
+	o := newObserver(tracer)
+
+	// up --atomic notifies about applied migrations after the commit.
+	ctx, runSpan := tracer.Start(context.Background(), "migrations.run")
+	m1 := &migrations.Migration{Version: 1, UpTx: true}
+	m2 := &migrations.Migration{Version: 2, UpTx: true}
+	o.BeforeMigration(ctx, m1, "up")
+	o.BeforeMigration(ctx, m2, "up")
+	o.AfterMigration(ctx, &migrations.ExecutedMigration{Migration: m1, Direction: "up"})
+	o.AfterMigration(ctx, &migrations.ExecutedMigration{Migration: m2, Direction: "up"})
+	o.AfterRun(ctx, &migrations.RunResult{}, nil)
+	runSpan.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, wanted 3", len(spans))
+	}
+	for i, span := range spans[:2] {
+		for _, kv := range span.Attributes {
+			if kv.Key == "migrations.version" && kv.Value.AsInt64() != int64(i+1) {
+				t.Fatalf("got version %d, wanted %d", kv.Value.AsInt64(), i+1)
+			}
+		}
+	}
+
+	// Migrations before the failed one are rolled back.
+	exporter.Reset()
+	ctx, runSpan = tracer.Start(context.Background(), "migrations.run")
+	o.BeforeMigration(ctx, m1, "up")
+	o.BeforeMigration(ctx, m2, "up")
+	o.OnError(ctx, m2, "up", errors.New("boom"))
+	o.AfterRun(ctx, &migrations.RunResult{}, errors.New("boom"))
+	runSpan.End()
+
+	spans = exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, wanted 3", len(spans))
+	}
+	for _, span := range spans[:2] {
+		if span.Status.Code != codes.Error {
+			t.Fatalf("got status %v, wanted error", span.Status.Code)
+		}
+	}
+	if len(o.spans) != 0 || len(o.current) != 0 {
+		t.Fatalf("got %d unfinished spans", len(o.spans))
+	}
+}
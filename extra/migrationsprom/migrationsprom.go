@@ -0,0 +1,147 @@
+// Package migrationsprom exports migration state and run metrics to Prometheus.
+package migrationsprom
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/go-pg/migrations/v8"
+)
+
+const namespace = "gopg_migrations"
+
+// Collector is a prometheus.Collector that reports the database version and
+// the number of pending migrations on scrape and records metrics of runs.
+type Collector struct {
+	coll *migrations.Collection
+	db   migrations.DB
+
+	up      *prometheus.Desc
+	version *prometheus.Desc
+	pending *prometheus.Desc
+
+	runDuration       *prometheus.HistogramVec
+	lastRunDuration   prometheus.Gauge
+	runFailures       *prometheus.CounterVec
+	migrationDuration *prometheus.HistogramVec
+}
+
+var (
+	_ prometheus.Collector   = (*Collector)(nil)
+	_ migrations.Observer    = (*Collector)(nil)
+	_ migrations.RunObserver = (*Collector)(nil)
+)
+
+// NewCollector returns a collector that queries the db on scrape.
+// It is added to the collection observers to record metrics of runs.
+func NewCollector(coll *migrations.Collection, db migrations.DB) *Collector {
+	c := &Collector{
+		coll: coll,
+		db:   db,
+
+		up: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "up"),
+			"Whether the migrations table was queried successfully.",
+			nil, nil),
+		version: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "version"),
+			"Current database version.",
+			nil, nil),
+		pending: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "pending"),
+			"Number of migrations that are not applied yet.",
+			nil, nil),
+
+		runDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "run_duration_seconds",
+			Help:      "Duration of runs by command.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+		}, []string{"command"}),
+		lastRunDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_run_duration_seconds",
+			Help:      "Duration of the last run.",
+		}),
+		runFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "run_failures_total",
+			Help:      "Number of failed runs by command.",
+		}, []string{"command"}),
+		migrationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "migration_duration_seconds",
+			Help:      "Duration of executed migrations by direction.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+		}, []string{"direction"}),
+	}
+	coll.AddObserver(c)
+	return c
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.up
+	ch <- c.version
+	ch <- c.pending
+	c.runDuration.Describe(ch)
+	c.lastRunDuration.Describe(ch)
+	c.runFailures.Describe(ch)
+	c.migrationDuration.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collectStatus(ch)
+	c.runDuration.Collect(ch)
+	c.lastRunDuration.Collect(ch)
+	c.runFailures.Collect(ch)
+	c.migrationDuration.Collect(ch)
+}
+
+func (c *Collector) collectStatus(ch chan<- prometheus.Metric) {
+	version, err := c.coll.Version(c.db)
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 0)
+		return
+	}
+	status, err := c.coll.Status(c.db)
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 0)
+		return
+	}
+
+	var pending int
+	for _, st := range status {
+		if st.State == migrations.StatePending {
+			pending++
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(c.version, prometheus.GaugeValue, float64(version))
+	ch <- prometheus.MustNewConstMetric(c.pending, prometheus.GaugeValue, float64(pending))
+}
+
+// BeforeMigration implements migrations.Observer.
+func (c *Collector) BeforeMigration(ctx context.Context, m *migrations.Migration, direction string) {}
+
+// AfterMigration records the migration duration.
+func (c *Collector) AfterMigration(ctx context.Context, m *migrations.ExecutedMigration) {
+	c.migrationDuration.WithLabelValues(m.Direction).Observe(m.Duration.Seconds())
+}
+
+// OnLockWait implements migrations.Observer.
+func (c *Collector) OnLockWait(ctx context.Context, lock string) {}
+
+// OnError implements migrations.Observer. Failures are counted by AfterRun.
+func (c *Collector) OnError(ctx context.Context, m *migrations.Migration, direction string, err error) {
+}
+
+// AfterRun records the run duration and failures.
+func (c *Collector) AfterRun(ctx context.Context, res *migrations.RunResult, err error) {
+	c.runDuration.WithLabelValues(res.Command.Name).Observe(res.Duration.Seconds())
+	c.lastRunDuration.Set(res.Duration.Seconds())
+	if err != nil {
+		c.runFailures.WithLabelValues(res.Command.Name).Inc()
+	}
+}
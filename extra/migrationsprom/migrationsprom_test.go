@@ -0,0 +1,69 @@
+package migrationsprom
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/go-pg/migrations/v8"
+)
+
+// brokenDB fails all queries.
+type brokenDB struct {
+	migrations.DB
+}
+
+func (brokenDB) QueryOne(model, query interface{}, params ...interface{}) (orm.Result, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestCollectorUp(t *testing.T) {
+	c := NewCollector(migrations.NewCollection(), brokenDB{})
+
+	wanted := `
+# HELP gopg_migrations_up Whether the migrations table was queried successfully.
+# TYPE gopg_migrations_up gauge
+gopg_migrations_up 0
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(wanted), "gopg_migrations_up")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCollectorRuns(t *testing.T) {
+	c := NewCollector(migrations.NewCollection(), brokenDB{})
+
+	ctx := context.Background()
+	c.AfterMigration(ctx, &migrations.ExecutedMigration{
+		Migration: &migrations.Migration{Version: 1},
+		Direction: "up",
+		Duration:  100 * time.Millisecond,
+	})
+	c.AfterRun(ctx, &migrations.RunResult{
+		Command:  migrations.Command{Name: "up"},
+		Duration: 2 * time.Second,
+	}, nil)
+	c.AfterRun(ctx, &migrations.RunResult{
+		Command:  migrations.Command{Name: "up"},
+		Duration: time.Second,
+	}, errors.New("migration failed"))
+
+	if got := testutil.ToFloat64(c.lastRunDuration); got != 1 {
+		t.Fatalf("got last run duration %v, wanted 1", got)
+	}
+	if got := testutil.ToFloat64(c.runFailures.WithLabelValues("up")); got != 1 {
+		t.Fatalf("got %v failures, wanted 1", got)
+	}
+	if got := testutil.CollectAndCount(c.runDuration); got != 1 {
+		t.Fatalf("got %d run duration metrics, wanted 1", got)
+	}
+	if got := testutil.CollectAndCount(c.migrationDuration); got != 1 {
+		t.Fatalf("got %d migration duration metrics, wanted 1", got)
+	}
+}
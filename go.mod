@@ -1,10 +1,19 @@
 module github.com/go-pg/migrations/v8
 
-go 1.16
+go 1.21
+
+require github.com/go-pg/pg/v10 v10.4.0
 
 require (
-	github.com/go-pg/pg/v10 v10.4.0
+	github.com/go-pg/zerochecker v0.2.0 // indirect
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/tmthrgd/go-hex v0.0.0-20190904060850-447a3041c3bc // indirect
+	github.com/vmihailenco/bufpool v0.1.11 // indirect
+	github.com/vmihailenco/msgpack/v5 v5.0.0-beta.1 // indirect
+	github.com/vmihailenco/tagparser v0.1.2 // indirect
+	go.opentelemetry.io/otel v0.13.0 // indirect
 	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897 // indirect
 	golang.org/x/net v0.0.0-20201016165138-7b1cca2348c0 // indirect
 	golang.org/x/sys v0.0.0-20201017003518-b09fb700fbb7 // indirect
+	mellium.im/sasl v0.2.1 // indirect
 )
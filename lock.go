@@ -0,0 +1,140 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// Locker provides mutual exclusion between concurrent runs.
+// The lock is held for the whole run including migrations
+// that are not executed in a transaction.
+type Locker interface {
+	// Lock blocks until the lock is acquired and returns
+	// a function that releases the lock.
+	Lock(ctx context.Context, db DB) (unlock func() error, err error)
+}
+
+// LockTimeoutError is returned when the lock is not acquired
+// within the timeout.
+type LockTimeoutError struct {
+	Timeout time.Duration
+	// PID of the backend holding the lock or 0 if it is unknown.
+	PID int
+}
+
+func (e *LockTimeoutError) Error() string {
+	return fmt.Sprintf(
+		"migrations lock is not acquired in %s; it is held by pid=%d",
+		e.Timeout, e.PID)
+}
+
+const advisoryLockPollInterval = 500 * time.Millisecond
+
+// AdvisoryLocker is a Locker that uses session-level
+// PostgreSQL advisory lock.
+type AdvisoryLocker struct {
+	key     int64
+	timeout time.Duration
+}
+
+var _ Locker = (*AdvisoryLocker)(nil)
+
+// NewAdvisoryLocker returns a Locker that uses advisory lock with the key.
+// The key must be the same for all applications running migrations
+// on the database.
+func NewAdvisoryLocker(key int64) *AdvisoryLocker {
+	return &AdvisoryLocker{
+		key: key,
+	}
+}
+
+// SetTimeout sets how long to wait for the lock before returning
+// LockTimeoutError. By default Lock waits until the context is done.
+func (l *AdvisoryLocker) SetTimeout(timeout time.Duration) *AdvisoryLocker {
+	l.timeout = timeout
+	return l
+}
+
+func (l *AdvisoryLocker) Lock(ctx context.Context, db DB) (func() error, error) {
+	// Session-level lock must be acquired and released using the same connection.
+	var release func() error
+	if v, ok := db.(*pg.DB); ok {
+		conn := v.Conn()
+		db = conn
+		release = conn.Close
+	}
+
+	err := l.lock(ctx, db)
+	if err != nil {
+		if release != nil {
+			_ = release()
+		}
+		return nil, err
+	}
+
+	return func() error {
+		// The lock must be released even if the context is cancelled.
+		_, err := withContext(context.Background(), db).Exec(
+			"SELECT pg_advisory_unlock(?)", l.key)
+		if release != nil {
+			if closeErr := release(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
+
+func (l *AdvisoryLocker) lock(ctx context.Context, db DB) error {
+	var deadline time.Time
+	if l.timeout > 0 {
+		deadline = time.Now().Add(l.timeout)
+	}
+
+	for {
+		var locked bool
+		_, err := db.QueryOne(pg.Scan(&locked), "SELECT pg_try_advisory_lock(?)", l.key)
+		if err != nil {
+			return err
+		}
+		if locked {
+			return nil
+		}
+
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			pid, err := l.holder(db)
+			if err != nil {
+				return err
+			}
+			return &LockTimeoutError{
+				Timeout: l.timeout,
+				PID:     pid,
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(advisoryLockPollInterval):
+		}
+	}
+}
+
+// holder returns the pid of the backend holding the lock.
+func (l *AdvisoryLocker) holder(db DB) (int, error) {
+	var pid int
+	// Bigint keys are stored as two halves in classid and objid.
+	_, err := db.QueryOne(pg.Scan(&pid), `
+		SELECT pid FROM pg_locks
+		WHERE locktype = 'advisory' AND granted
+			AND classid::bigint = ? AND objid::bigint = ? AND objsubid = 1
+		LIMIT 1
+	`, uint64(l.key)>>32, uint64(l.key)&0xffffffff)
+	if err == pg.ErrNoRows {
+		return 0, nil
+	}
+	return pid, err
+}
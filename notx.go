@@ -0,0 +1,198 @@
+package migrations
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// nonTxStatement is a statement that can't run inside a transaction block,
+// e.g. CREATE INDEX CONCURRENTLY.
+type nonTxStatement struct {
+	name string
+	line int
+	// alone reports whether the statement is the only statement of its
+	// query. PostgreSQL runs queries with multiple statements in an implicit
+	// transaction block, so such statements must be sent separately.
+	alone bool
+}
+
+// findNonTxStatement returns the first statement of the queries
+// that can't run inside a transaction block or nil.
+func findNonTxStatement(queries []sqlQuery) *nonTxStatement {
+	for _, q := range queries {
+		statements := splitSQL(q.query)
+		for _, st := range statements {
+			words, start := sqlWords(st.query, 10)
+			name := nonTxStatementName(words)
+			if name == "" {
+				continue
+			}
+			return &nonTxStatement{
+				name:  name,
+				line:  q.line + st.line - 1 + strings.Count(st.query[:start], "\n"),
+				alone: len(statements) == 1,
+			}
+		}
+	}
+	return nil
+}
+
+// sqlMigrationTx reports whether the SQL migration must be run in
+// a transaction. Migrations with statements that can't run inside
+// a transaction block are run without it unless a transaction is
+// required by the directives.
+//
+// splitErr is returned when such statement is not the only statement
+// of its query. It does not fail discovery, because the statements
+// are sent separately when SplitSQLStatements is enabled, which may
+// happen after discovery. See checkNonTxStatements.
+func sqlMigrationTx(b []byte, d *sqlDirectives, ext bool) (useTx bool, splitErr, err error) {
+	useTx = d.useTx(ext)
+
+	queries, err := splitSQLQueries(bytes.NewReader(b), false)
+	if err != nil {
+		return false, nil, err
+	}
+
+	st := findNonTxStatement(queries)
+	if st == nil {
+		return useTx, nil, nil
+	}
+	if useTx && (d.tx || d.isolation != "") {
+		return false, nil, fmt.Errorf(
+			"line %d: %s can't run in a transaction block, "+
+				"but the migration requires a transaction", st.line, st.name)
+	}
+	if !st.alone {
+		splitErr = fmt.Errorf(
+			"line %d: %s can't run in a transaction block and must be "+
+				"the only statement of the query; separate it with --gopg:split "+
+				"or use SplitSQLStatements(true)", st.line, st.name)
+	}
+	return false, splitErr, nil
+}
+
+// checkNonTxStatements returns an error when an SQL migration has
+// a statement that can't run in a transaction block, but is sent
+// together with other statements. It is checked when a command is run,
+// so SplitSQLStatements can be enabled after discovery.
+func (c *Collection) checkNonTxStatements(migrations []*Migration) error {
+	if c.sqlStatementsSplit {
+		return nil
+	}
+	for _, m := range migrations {
+		if m.upSplitErr != nil {
+			return fmt.Errorf("file=%q: %w", m.upFile, m.upSplitErr)
+		}
+		if m.downSplitErr != nil {
+			return fmt.Errorf("file=%q: %w", m.downFile, m.downSplitErr)
+		}
+	}
+	return nil
+}
+
+// nonTxStatementName returns the name of the statement starting with
+// the words if it can't run inside a transaction block. Statements
+// that can run in transactions in recent PostgreSQL versions, like
+// ALTER TYPE ... ADD VALUE before PostgreSQL 12, are not reported.
+func nonTxStatementName(w []string) string {
+	has := func(words ...string) bool {
+		if len(w) < len(words) {
+			return false
+		}
+		for i, word := range words {
+			if word != "" && w[i] != word {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch {
+	case has("VACUUM"):
+		return "VACUUM"
+	// CLUSTER without a table reclusters all previously clustered tables.
+	case len(w) == 1 && w[0] == "CLUSTER", len(w) == 2 && has("CLUSTER", "VERBOSE"):
+		return "CLUSTER"
+	case has("CREATE", "INDEX", "CONCURRENTLY"), has("CREATE", "UNIQUE", "INDEX", "CONCURRENTLY"):
+		return "CREATE INDEX CONCURRENTLY"
+	case has("DROP", "INDEX", "CONCURRENTLY"):
+		return "DROP INDEX CONCURRENTLY"
+	case has("REINDEX", "DATABASE"), has("REINDEX", "SYSTEM"), has("REINDEX", "SCHEMA"):
+		return "REINDEX " + w[1]
+	case has("REINDEX", "", "CONCURRENTLY"):
+		return "REINDEX CONCURRENTLY"
+	case has("CREATE", "DATABASE"), has("DROP", "DATABASE"),
+		has("CREATE", "TABLESPACE"), has("DROP", "TABLESPACE"):
+		return w[0] + " " + w[1]
+	case has("ALTER", "SYSTEM"):
+		return "ALTER SYSTEM"
+	case has("ALTER", "TABLE") && detachConcurrently(w[2:]):
+		return "DETACH PARTITION CONCURRENTLY"
+	}
+	return ""
+}
+
+// detachConcurrently reports whether the words of ALTER TABLE
+// detach a partition concurrently.
+func detachConcurrently(w []string) bool {
+	for i := 0; i+3 < len(w); i++ {
+		if w[i] == "DETACH" && w[i+1] == "PARTITION" && w[i+3] == "CONCURRENTLY" {
+			return true
+		}
+	}
+	return false
+}
+
+// txBlockError adds a hint to the error of a statement that can't run
+// inside a transaction block on the server, e.g. ALTER TYPE ... ADD VALUE
+// before PostgreSQL 12.
+func txBlockError(err error) error {
+	var pgErr pg.Error
+	if errors.As(err, &pgErr) && pgErr.Field('C') == "25001" {
+		return fmt.Errorf("%w; use --gopg:notx to run the migration without a transaction", err)
+	}
+	return err
+}
+
+// sqlWords returns up to n first upper-cased words of the query skipping
+// comments and parenthesized options like in REINDEX (VERBOSE) INDEX.
+// start is the offset of the first word.
+func sqlWords(query string, n int) (words []string, start int) {
+	for i := 0; i < len(query) && len(words) < n; {
+		switch c := query[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end == -1 {
+				return words, start
+			}
+			i += end + 1
+		case strings.HasPrefix(query[i:], "/*"):
+			i = blockCommentEnd(query, i)
+		case c == '(':
+			end := strings.IndexByte(query[i:], ')')
+			if end == -1 {
+				return words, start
+			}
+			i += end + 1
+		case c == ';' || c == ')':
+			return words, start
+		default:
+			if len(words) == 0 {
+				start = i
+			}
+			j := i
+			for i < len(query) && !strings.ContainsRune(" \t\r\n();", rune(query[i])) {
+				i++
+			}
+			words = append(words, strings.ToUpper(query[j:i]))
+		}
+	}
+	return words, start
+}
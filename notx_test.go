@@ -0,0 +1,147 @@
+package migrations
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestNonTxStatementName(t *testing.T) {
+	tests := []struct {
+		query  string
+		wanted string
+	}{
+		{"CREATE INDEX users_email_idx ON users (email)", ""},
+		{"create index concurrently users_email_idx on users (email)", "CREATE INDEX CONCURRENTLY"},
+		{"-- comment\nCREATE UNIQUE INDEX CONCURRENTLY idx ON users (email)", "CREATE INDEX CONCURRENTLY"},
+		{"/* comment */ DROP INDEX CONCURRENTLY idx", "DROP INDEX CONCURRENTLY"},
+		{"VACUUM (ANALYZE) users", "VACUUM"},
+		{"REINDEX (VERBOSE) INDEX CONCURRENTLY idx", "REINDEX CONCURRENTLY"},
+		{"REINDEX INDEX idx", ""},
+		{"REINDEX DATABASE app", "REINDEX DATABASE"},
+		{"REINDEX SCHEMA public", "REINDEX SCHEMA"},
+		{"CLUSTER", "CLUSTER"},
+		{"CLUSTER;", "CLUSTER"},
+		{"CLUSTER (VERBOSE)", "CLUSTER"},
+		{"CLUSTER users USING users_pkey", ""},
+		{"ALTER TABLE measurements DETACH PARTITION measurements_2023 CONCURRENTLY",
+			"DETACH PARTITION CONCURRENTLY"},
+		{"ALTER TABLE IF EXISTS ONLY public.measurements DETACH PARTITION measurements_2023 CONCURRENTLY",
+			"DETACH PARTITION CONCURRENTLY"},
+		{"ALTER TABLE measurements DETACH PARTITION measurements_2023", ""},
+		{"CREATE DATABASE app", "CREATE DATABASE"},
+		{"ALTER SYSTEM SET work_mem = '64MB'", "ALTER SYSTEM"},
+		{"ALTER TYPE mood ADD VALUE 'happy'", ""},
+		{"ALTER TYPE mood RENAME VALUE 'sad' TO 'unhappy'", ""},
+		{"SELECT 'VACUUM'", ""},
+	}
+	for _, test := range tests {
+		words, _ := sqlWords(test.query, 10)
+		got := nonTxStatementName(words)
+		if got != test.wanted {
+			t.Errorf("nonTxStatementName(%q) = %q, wanted %q", test.query, got, test.wanted)
+		}
+	}
+}
+
+func TestDiscoverNonTxStatements(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1_users.tx.up.sql": {Data: []byte("CREATE TABLE users (email text)")},
+		"migrations/2_index.tx.up.sql": {Data: []byte(
+			"CREATE INDEX CONCURRENTLY users_email_idx ON users (email)")},
+		"migrations/2_index.tx.down.sql": {Data: []byte(
+			"DROP INDEX CONCURRENTLY users_email_idx;\n--gopg:split\nSELECT 1")},
+		"migrations/3_mood.tx.up.sql": {Data: []byte(
+			"ALTER TYPE mood ADD VALUE 'happy';\nALTER TYPE mood ADD VALUE 'sad';")},
+	}
+
+	coll := NewCollection()
+	coll.DisableSQLAutodiscover(true)
+	if err := coll.DiscoverSQLMigrationsFS(fsys, "migrations", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ms := coll.Migrations()
+	if len(ms) != 3 {
+		t.Fatalf("got %d migrations, wanted 3", len(ms))
+	}
+	if !ms[0].UpTx {
+		t.Fatal("migration 1 must run in a transaction")
+	}
+	if ms[1].UpTx || ms[1].DownTx {
+		t.Fatal("migration 2 must run without a transaction")
+	}
+	if !ms[2].UpTx {
+		t.Fatal("migration 3 must run in a transaction")
+	}
+}
+
+func TestTxBlockError(t *testing.T) {
+	err := txBlockError(pgError{"25001"})
+	if !strings.HasSuffix(err.Error(), "use --gopg:notx to run the migration without a transaction") {
+		t.Fatalf("got %q", err)
+	}
+	if !errors.Is(err, pgError{"25001"}) {
+		t.Fatal("the original error is not wrapped")
+	}
+
+	err = pgError{"42601"}
+	if got := txBlockError(err); got != err {
+		t.Fatalf("got %q, wanted %q", got, err)
+	}
+}
+
+func TestDiscoverNonTxStatementsErrors(t *testing.T) {
+	tests := []struct {
+		file   string
+		sql    string
+		wanted string
+	}{{
+		file: "1_index.up.sql",
+		sql:  "--gopg:tx\nCREATE INDEX CONCURRENTLY idx ON users (email)",
+		wanted: `file="migrations/1_index.up.sql": line 2: CREATE INDEX CONCURRENTLY ` +
+			`can't run in a transaction block, but the migration requires a transaction`,
+	}, {
+		file: "1_isolation.up.sql",
+		sql:  "--gopg:isolation serializable\nVACUUM users",
+		wanted: `file="migrations/1_isolation.up.sql": line 2: VACUUM ` +
+			`can't run in a transaction block, but the migration requires a transaction`,
+	}}
+	for _, test := range tests {
+		coll := NewCollection()
+		coll.DisableSQLAutodiscover(true)
+		err := coll.DiscoverSQLMigrationsFS(fstest.MapFS{
+			"migrations/" + test.file: {Data: []byte(test.sql)},
+		}, "migrations", nil)
+		if err == nil || !strings.HasPrefix(err.Error(), test.wanted) {
+			t.Errorf("got %v, wanted %s", err, test.wanted)
+		}
+	}
+
+}
+
+func TestCheckNonTxStatements(t *testing.T) {
+	coll := NewCollection()
+	coll.DisableSQLAutodiscover(true)
+	err := coll.DiscoverSQLMigrationsFS(fstest.MapFS{
+		"migrations/1_vacuum.up.sql": {Data: []byte("DELETE FROM users;\nVACUUM users;")},
+	}, "migrations", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = coll.checkNonTxStatements(coll.Migrations())
+	wanted := `file="migrations/1_vacuum.up.sql": line 2: VACUUM can't run in a transaction block ` +
+		`and must be the only statement of the query`
+	if err == nil || !strings.HasPrefix(err.Error(), wanted) {
+		t.Fatalf("got %v, wanted %s", err, wanted)
+	}
+
+	// Statements split on semicolons are sent separately,
+	// even when splitting is enabled after discovery.
+	coll.SplitSQLStatements(true)
+	if err := coll.checkNonTxStatements(coll.Migrations()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,172 @@
+package migrations
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Observer is notified about migration lifecycle events.
+type Observer interface {
+	// BeforeMigration is called before the migration is run
+	// in the direction, i.e. "up" or "down".
+	BeforeMigration(ctx context.Context, m *Migration, direction string)
+	// AfterMigration is called after the migration succeeds. Migrations
+	// run in a single transaction, e.g. by up --atomic, are reported
+	// after it is committed.
+	AfterMigration(ctx context.Context, m *ExecutedMigration)
+	// OnLockWait is called when acquiring the lock, e.g. the migrations
+	// table lock, takes longer than expected. It may be called
+	// from another goroutine.
+	OnLockWait(ctx context.Context, lock string)
+	// OnError is called when the migration fails. m is nil when
+	// the error is not related to a migration, e.g. the migrations
+	// table can't be locked.
+	OnError(ctx context.Context, m *Migration, direction string, err error)
+}
+
+// StatementObserver is an optional interface of Observer. It is notified
+// about SQL statements before they are executed by SQL migrations.
+type StatementObserver interface {
+	OnStatement(ctx context.Context, file string, line int, query string)
+}
+
+// RunObserver is an optional interface of Observer. It is notified
+// when Exec, Run or RunContext completes.
+type RunObserver interface {
+	AfterRun(ctx context.Context, res *RunResult, err error)
+}
+
+// RetryObserver is an optional interface of Observer. It is notified
+// when a failed migration is going to be retried after the delay.
+// See RetryPolicy.
+type RetryObserver interface {
+	OnRetry(ctx context.Context, m *Migration, direction string, attempt int, delay time.Duration, err error)
+}
+
+// lockWaitDelay is how long acquiring a lock may take
+// before observers are notified.
+var lockWaitDelay = 500 * time.Millisecond
+
+type slogObserver struct {
+	logger *slog.Logger
+}
+
+var (
+	_ Observer      = (*slogObserver)(nil)
+	_ RetryObserver = (*slogObserver)(nil)
+)
+
+func (o *slogObserver) BeforeMigration(ctx context.Context, m *Migration, direction string) {
+	o.logger.DebugContext(ctx, "running migration",
+		slog.Int64("version", m.Version),
+		slog.String("name", m.Name),
+		slog.String("direction", direction))
+}
+
+func (o *slogObserver) AfterMigration(ctx context.Context, m *ExecutedMigration) {
+	o.logger.InfoContext(ctx, "migration succeeded",
+		slog.Int64("version", m.Version),
+		slog.String("name", m.Name),
+		slog.String("direction", m.Direction),
+		slog.Duration("duration", m.Duration))
+}
+
+func (o *slogObserver) OnLockWait(ctx context.Context, lock string) {
+	o.logger.InfoContext(ctx, "waiting for lock", slog.String("lock", lock))
+}
+
+func (o *slogObserver) OnError(ctx context.Context, m *Migration, direction string, err error) {
+	if m == nil {
+		o.logger.ErrorContext(ctx, "migrations failed", slog.Any("error", err))
+		return
+	}
+	o.logger.ErrorContext(ctx, "migration failed",
+		slog.Int64("version", m.Version),
+		slog.String("name", m.Name),
+		slog.String("direction", direction),
+		slog.Any("error", err))
+}
+
+func (o *slogObserver) OnRetry(
+	ctx context.Context, m *Migration, direction string, attempt int, delay time.Duration, err error,
+) {
+	o.logger.WarnContext(ctx, "retrying migration",
+		slog.Int64("version", m.Version),
+		slog.String("name", m.Name),
+		slog.String("direction", direction),
+		slog.Int("attempt", attempt),
+		slog.Duration("delay", delay),
+		slog.Any("error", err))
+}
+
+func (c *Collection) activeObservers() []Observer {
+	if c.logger == nil {
+		return c.observers
+	}
+	observers := make([]Observer, 0, len(c.observers)+1)
+	observers = append(observers, &slogObserver{logger: c.logger})
+	return append(observers, c.observers...)
+}
+
+func (c *Collection) beforeMigration(ctx context.Context, m *Migration, direction string) {
+	for _, o := range c.activeObservers() {
+		o.BeforeMigration(ctx, m, direction)
+	}
+}
+
+func (c *Collection) afterMigration(ctx context.Context, m *ExecutedMigration) {
+	for _, o := range c.activeObservers() {
+		o.AfterMigration(ctx, m)
+	}
+}
+
+func (c *Collection) onError(ctx context.Context, m *Migration, direction string, err error) {
+	for _, o := range c.activeObservers() {
+		o.OnError(ctx, m, direction, err)
+	}
+}
+
+func (c *Collection) onStatement(ctx context.Context, file string, line int, query string) {
+	for _, o := range c.observers {
+		if so, ok := o.(StatementObserver); ok {
+			so.OnStatement(ctx, file, line, query)
+		}
+	}
+}
+
+func (c *Collection) onRetry(
+	ctx context.Context, m *Migration, direction string, attempt int, delay time.Duration, err error,
+) {
+	for _, o := range c.activeObservers() {
+		if ro, ok := o.(RetryObserver); ok {
+			ro.OnRetry(ctx, m, direction, attempt, delay, err)
+		}
+	}
+}
+
+func (c *Collection) afterRun(ctx context.Context, res *RunResult, err error) {
+	for _, o := range c.observers {
+		if ro, ok := o.(RunObserver); ok {
+			ro.AfterRun(ctx, res, err)
+		}
+	}
+}
+
+// waitLock calls acquire and notifies observers
+// when it does not return within lockWaitDelay.
+func (c *Collection) waitLock(ctx context.Context, lock string, acquire func() error) error {
+	observers := c.activeObservers()
+	if len(observers) == 0 {
+		return acquire()
+	}
+
+	timer := time.AfterFunc(lockWaitDelay, func() {
+		for _, o := range observers {
+			o.OnLockWait(ctx, lock)
+		}
+	})
+	defer timer.Stop()
+
+	return acquire()
+}
@@ -0,0 +1,71 @@
+package migrations
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+type lockWaitObserver struct {
+	Observer
+	locks chan string
+}
+
+func (o *lockWaitObserver) OnLockWait(ctx context.Context, lock string) {
+	o.locks <- lock
+}
+
+func TestWaitLock(t *testing.T) {
+	defer func(d time.Duration) { lockWaitDelay = d }(lockWaitDelay)
+	lockWaitDelay = time.Millisecond
+
+	o := &lockWaitObserver{locks: make(chan string, 1)}
+	coll := NewCollection().AddObserver(o)
+
+	err := coll.waitLock(context.Background(), "gopg_migrations", func() error {
+		select {
+		case lock := <-o.locks:
+			if lock != "gopg_migrations" {
+				t.Errorf("got lock %q, wanted gopg_migrations", lock)
+			}
+		case <-time.After(time.Second):
+			t.Error("OnLockWait is not called")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	coll := NewCollection().SetLogger(logger)
+
+	ctx := context.Background()
+	m := &Migration{Version: 5, Name: "add_email_to_users"}
+	coll.afterMigration(ctx, &ExecutedMigration{
+		Migration: m,
+		Direction: "up",
+		Duration:  120 * time.Millisecond,
+	})
+	coll.onError(ctx, m, "down", errors.New("boom"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, wanted 2", len(lines))
+	}
+	for _, s := range []string{"version=5", "name=add_email_to_users", "direction=up", "duration=120ms"} {
+		if !strings.Contains(lines[0], s) {
+			t.Fatalf("line %q does not contain %q", lines[0], s)
+		}
+	}
+	if !strings.Contains(lines[1], "level=ERROR") || !strings.Contains(lines[1], "error=boom") {
+		t.Fatalf("unexpected line: %q", lines[1])
+	}
+}
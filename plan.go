@@ -0,0 +1,143 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PlannedMigration is a migration that would be run by a command.
+type PlannedMigration struct {
+	*Migration
+
+	// Direction is either up or down.
+	Direction string
+	Tx        bool
+	// File is the Go or SQL file the migration was loaded from.
+	File string
+	// Queries are the statements of an SQL migration
+	// in the order they would be executed.
+	Queries []string
+}
+
+func (m *PlannedMigration) String() string {
+	s := fmt.Sprintf("%s migration=%d %s", m.Direction, m.Version, m.Name)
+	if m.Tx {
+		s += " (tx)"
+	}
+	if m.File != "" {
+		s += " " + m.File
+	}
+	return s
+}
+
+// Plan returns migrations that would be run by the command without
+// running them. Supported commands are:
+// - up [target|+N] - the default;
+// - down [N];
+// - down-to version;
+// - goto version;
+// - reset.
+//
+// Like Run, down commands fail when they reach an irreversible migration.
+func (c *Collection) Plan(db DB, a ...string) ([]*PlannedMigration, error) {
+	migrations, err := c.discoverMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := validateMigrations(migrations); err != nil {
+		return nil, err
+	}
+	if err := c.checkNonTxStatements(migrations); err != nil {
+		return nil, err
+	}
+
+	version, err := c.Version(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.plan(db, migrations, version, a...)
+}
+
+func (c *Collection) plan(
+	db DB, migrations []*Migration, version int64, a ...string,
+) ([]*PlannedMigration, error) {
+	st, err := parseSteps(ParseCommand(a...), version)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied map[int64]*appliedMigration
+	if c.outOfOrder {
+		applied, err = c.appliedVersions(db, migrations)
+		if err != nil {
+			return nil, err
+		}
+	}
+	isApplied := func(m *Migration) bool {
+		if applied != nil {
+			return applied[m.Version] != nil
+		}
+		return m.Version <= version
+	}
+
+	var plan []*PlannedMigration
+	if st.direction == "up" {
+		for _, m := range migrations {
+			if m.Version > st.target || (st.n > 0 && len(plan) == st.n) {
+				break
+			}
+			if !isApplied(m) {
+				plan = append(plan, newPlannedMigration(m, "up"))
+			}
+		}
+	} else {
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if m.Version <= st.target || (st.n > 0 && len(plan) == st.n) {
+				break
+			}
+			if m.Version > version || !isApplied(m) {
+				continue
+			}
+			if err := c.checkReversible(m); err != nil {
+				return nil, err
+			}
+			plan = append(plan, newPlannedMigration(m, "down"))
+		}
+	}
+
+	for _, m := range plan {
+		if m.sqlFS == nil || m.File == "" {
+			continue
+		}
+		queries, err := c.readSQLMigration(m.sqlFS, m.File)
+		if err != nil {
+			return nil, err
+		}
+		for _, q := range queries {
+			m.Queries = append(m.Queries, q.query)
+		}
+	}
+
+	return plan, nil
+}
+
+func newPlannedMigration(m *Migration, direction string) *PlannedMigration {
+	p := &PlannedMigration{
+		Migration: m,
+		Direction: direction,
+	}
+	if direction == "up" {
+		p.Tx = m.UpTx
+		p.File = m.upFile
+	} else {
+		p.Tx = m.DownTx
+		p.File = m.downFile
+	}
+	return p
+}
+
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
@@ -0,0 +1,145 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// Preflight checks sessions with long-running transactions, including idle
+// in transaction sessions, before commands that run migrations. Migrations
+// waiting for locks held by such sessions block all other queries on
+// the tables. See Collection.SetPreflight.
+type Preflight struct {
+	// MaxTransactionAge is the age of transactions that are considered
+	// blocking. Zero makes all open transactions blocking.
+	MaxTransactionAge time.Duration
+	// Cancel cancels queries of active blocking sessions using
+	// pg_cancel_backend instead of failing the run. Queries cancelled
+	// inside an explicit transaction keep its locks until the client
+	// rolls it back, and idle in transaction sessions have no query
+	// to cancel, so they still fail the run.
+	Cancel bool
+	// Terminate terminates all blocking sessions using pg_terminate_backend.
+	Terminate bool
+}
+
+// BlockingSession is a session found by the preflight check.
+type BlockingSession struct {
+	PID            int
+	User           string
+	State          string
+	TransactionAge time.Duration
+	Query          string
+}
+
+func (s *BlockingSession) String() string {
+	const maxQueryLen = 100
+
+	query := strings.Join(strings.Fields(s.Query), " ")
+	if len(query) > maxQueryLen {
+		query = query[:maxQueryLen] + "..."
+	}
+	return fmt.Sprintf("pid=%d user=%s state=%q age=%s query=%q",
+		s.PID, s.User, s.State, s.TransactionAge.Round(time.Second), query)
+}
+
+// BlockingSessionsError is returned when the preflight check finds
+// blocking sessions.
+type BlockingSessionsError struct {
+	MaxTransactionAge time.Duration
+	Sessions          []*BlockingSession
+}
+
+func (e *BlockingSessionsError) Error() string {
+	s := make([]string, len(e.Sessions))
+	for i, session := range e.Sessions {
+		s[i] = session.String()
+	}
+	return fmt.Sprintf(
+		"found %d sessions with transactions older than %s: %s",
+		len(e.Sessions), e.MaxTransactionAge, strings.Join(s, "; "))
+}
+
+// checkBlockingSessions runs the preflight check. Cancelled and terminated
+// sessions are reported as warnings.
+func (c *Collection) checkBlockingSessions(db DB, res *RunResult) error {
+	p := c.preflight
+
+	var rows []struct {
+		Pid     int
+		Usename string
+		State   string
+		AgeMs   int64
+		Query   string
+	}
+	var serverVersion int
+	_, err := db.QueryOne(pg.Scan(&serverVersion), "SELECT current_setting('server_version_num')::int")
+	if err != nil {
+		return err
+	}
+	// backend_type is not available before PostgreSQL 10,
+	// where background workers have no user and autovacuum
+	// workers are recognized by the query.
+	clientFilter := "backend_type = 'client backend'"
+	if serverVersion < 100000 {
+		clientFilter = "usename IS NOT NULL AND query NOT LIKE 'autovacuum:%'"
+	}
+
+	// Other runs waiting for the migrations table lock are not blocking.
+	_, err = db.Query(&rows, `
+		SELECT pid, usename, state, query,
+			(extract(epoch FROM now() - xact_start) * 1000)::bigint AS age_ms
+		FROM pg_stat_activity AS a
+		WHERE datname = current_database()
+			AND pid <> pg_backend_pid()
+			AND ?
+			AND xact_start < now() - ? * interval '1 millisecond'
+			AND NOT EXISTS (
+				SELECT 1 FROM pg_locks AS l
+				WHERE l.pid = a.pid AND NOT l.granted AND l.relation = to_regclass(?)
+			)
+		ORDER BY xact_start
+	`, pg.SafeQuery(clientFilter), p.MaxTransactionAge.Milliseconds(), c.tableName)
+	if err != nil {
+		return err
+	}
+
+	var blocking []*BlockingSession
+	for _, row := range rows {
+		s := &BlockingSession{
+			PID:            row.Pid,
+			User:           row.Usename,
+			State:          row.State,
+			TransactionAge: time.Duration(row.AgeMs) * time.Millisecond,
+			Query:          row.Query,
+		}
+
+		switch {
+		case p.Terminate:
+			_, err := db.Exec("SELECT pg_terminate_backend(?)", s.PID)
+			if err != nil {
+				return err
+			}
+			res.addWarning("terminated blocking session %s", s)
+		case p.Cancel && s.State == "active":
+			_, err := db.Exec("SELECT pg_cancel_backend(?)", s.PID)
+			if err != nil {
+				return err
+			}
+			res.addWarning("cancelled query of blocking session %s", s)
+		default:
+			blocking = append(blocking, s)
+		}
+	}
+
+	if len(blocking) > 0 {
+		return &BlockingSessionsError{
+			MaxTransactionAge: p.MaxTransactionAge,
+			Sessions:          blocking,
+		}
+	}
+	return nil
+}
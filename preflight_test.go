@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlockingSessionsError(t *testing.T) {
+	err := &BlockingSessionsError{
+		MaxTransactionAge: time.Minute,
+		Sessions: []*BlockingSession{{
+			PID:            42,
+			User:           "reports",
+			State:          "active",
+			TransactionAge: 5*time.Minute + 300*time.Millisecond,
+			Query:          "SELECT *\n  FROM users",
+		}, {
+			PID:            43,
+			User:           "app",
+			State:          "idle in transaction",
+			TransactionAge: 2 * time.Minute,
+			Query:          "UPDATE users SET email = $1",
+		}},
+	}
+
+	wanted := `found 2 sessions with transactions older than 1m0s: ` +
+		`pid=42 user=reports state="active" age=5m0s query="SELECT * FROM users"; ` +
+		`pid=43 user=app state="idle in transaction" age=2m0s query="UPDATE users SET email = $1"`
+	if got := err.Error(); got != wanted {
+		t.Fatalf("got %s, wanted %s", got, wanted)
+	}
+}
@@ -0,0 +1,130 @@
+package migrations
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Command is a command run by Exec, e.g. Command{Name: "up", Args: []string{"5"}}.
+type Command struct {
+	Name    string
+	Args    []string
+	Options RunOptions
+}
+
+// RunOptions change how a command is run.
+type RunOptions struct {
+	// Atomic makes up apply all migrations in a single transaction,
+	// so a failure rolls back the whole batch. Set by the --atomic flag.
+	Atomic bool
+}
+
+// ParseCommand parses command line arguments like "up 5" or "up --atomic".
+// The command defaults to up.
+func ParseCommand(a ...string) Command {
+	if len(a) == 0 {
+		return Command{Name: "up"}
+	}
+
+	var cmd Command
+	for _, arg := range a {
+		switch {
+		case arg == "--atomic":
+			cmd.Options.Atomic = true
+		case cmd.Name == "":
+			cmd.Name = arg
+			cmd.Args = []string{}
+		default:
+			cmd.Args = append(cmd.Args, arg)
+		}
+	}
+	if cmd.Name == "" {
+		cmd.Name = "up"
+	}
+	return cmd
+}
+
+func (cmd Command) String() string {
+	a := append([]string{cmd.Name}, cmd.Args...)
+	if cmd.Options.Atomic {
+		a = append(a, "--atomic")
+	}
+	return strings.Join(a, " ")
+}
+
+// ExecutedMigration is a migration run by Exec.
+type ExecutedMigration struct {
+	*Migration
+	Direction string
+	Duration  time.Duration
+}
+
+func (m *ExecutedMigration) String() string {
+	verb := "applied"
+	if m.Direction == "down" {
+		verb = "reverted"
+	}
+	return fmt.Sprintf("%s %s in %s", verb, m.Migration, m.Duration.Round(time.Millisecond))
+}
+
+// RunResult describes what a command did.
+type RunResult struct {
+	Command    Command
+	OldVersion int64
+	NewVersion int64
+	Duration   time.Duration
+
+	// Migrations are the migrations run by the command in the order
+	// they were run. Failed migrations are not included.
+	Migrations []*ExecutedMigration
+
+	// File is the migration file created by the create command.
+	File string
+	// Pending, Status and Plan are reported by the pending,
+	// status and plan commands.
+	Pending []*PendingMigration
+	Status  []*MigrationStatus
+	Plan    []*PlannedMigration
+
+	// Warnings are problems that did not fail the command.
+	Warnings []string
+}
+
+func (res *RunResult) addWarning(format string, args ...interface{}) {
+	res.Warnings = append(res.Warnings, fmt.Sprintf(format, args...))
+}
+
+// writeResult prints the result and returns the first write error.
+func writeResult(w io.Writer, res *RunResult) error {
+	var err error
+	writeln := func(a ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintln(w, a...)
+		}
+	}
+
+	for _, m := range res.Migrations {
+		writeln(m)
+	}
+	if res.File != "" {
+		writeln("created new migration", res.File)
+	}
+	for _, m := range res.Pending {
+		writeln(m)
+	}
+	if res.Status != nil && err == nil {
+		err = writeStatus(w, res.Status)
+	}
+	for _, m := range res.Plan {
+		writeln(m)
+		for _, q := range m.Queries {
+			writeln(indent(strings.TrimSpace(q), "    "))
+		}
+	}
+	for _, s := range res.Warnings {
+		writeln("warning:", s)
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package migrations
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		args   []string
+		wanted Command
+	}{
+		{nil, Command{Name: "up"}},
+		{[]string{"up", "5"}, Command{Name: "up", Args: []string{"5"}}},
+		{[]string{"down"}, Command{Name: "down", Args: []string{}}},
+		{
+			[]string{"up", "--atomic", "5"},
+			Command{Name: "up", Args: []string{"5"}, Options: RunOptions{Atomic: true}},
+		},
+		{[]string{"--atomic"}, Command{Name: "up", Options: RunOptions{Atomic: true}}},
+	}
+	for _, test := range tests {
+		got := ParseCommand(test.args...)
+		if !reflect.DeepEqual(got, test.wanted) {
+			t.Errorf("ParseCommand(%q) = %+v, wanted %+v", test.args, got, test.wanted)
+		}
+	}
+}
+
+func TestWriteResult(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeResult(&buf, &RunResult{
+		Command: Command{Name: "up"},
+		Migrations: []*ExecutedMigration{
+			{
+				Migration: &Migration{Version: 5, Name: "add_email_to_users"},
+				Direction: "up",
+				Duration:  120*time.Millisecond + 300*time.Microsecond,
+			},
+			{
+				Migration: &Migration{Version: 6},
+				Direction: "down",
+				Duration:  time.Second,
+			},
+		},
+		Warnings: []string{"version=6 is higher than target=5"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wanted := "applied 5 add_email_to_users in 120ms\n" +
+		"reverted 6 in 1s\n" +
+		"warning: version=6 is higher than target=5\n"
+	if buf.String() != wanted {
+		t.Fatalf("got %q, wanted %q", buf.String(), wanted)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk is full")
+}
+
+func TestWriteResultError(t *testing.T) {
+	results := []*RunResult{
+		{Migrations: []*ExecutedMigration{{Migration: &Migration{Version: 1}, Direction: "up"}}},
+		{File: "1_initial.up.sql"},
+		{Pending: []*PendingMigration{{Migration: &Migration{Version: 1}}}},
+		{Plan: []*PlannedMigration{{Migration: &Migration{Version: 1}, Direction: "up"}}},
+		{Warnings: []string{"database is not dirty"}},
+	}
+	for _, res := range results {
+		if err := writeResult(failingWriter{}, res); err == nil {
+			t.Errorf("writeResult(%+v) succeeded, wanted an error", res)
+		}
+	}
+}
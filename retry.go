@@ -0,0 +1,140 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// RetryPolicy retries transactional migrations that fail with
+// lock_not_available (55P03) or serialization_failure (40001) errors,
+// e.g. when DDL can't lock a busy table within the lock_timeout.
+// Failed attempts are rolled back and recorded in the migrations history.
+//
+// Migrations without a transaction may be partially applied,
+// so the policy does not apply to them. Migrations run by up --atomic
+// use the lock timeout, but are not retried.
+type RetryPolicy struct {
+	// LockTimeout is set as lock_timeout while the migration is run.
+	// The --gopg:lock_timeout directive takes precedence.
+	LockTimeout time.Duration
+	// MaxAttempts limits the number of attempts including the first one.
+	MaxAttempts int
+	// MinBackoff is the delay before the second attempt, which is doubled
+	// for every next attempt up to MaxBackoff. Defaults to 100ms and 10s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	minBackoff, maxBackoff := p.MinBackoff, p.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = 100 * time.Millisecond
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = 10 * time.Second
+	}
+
+	d := minBackoff
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// isRetryable reports whether the migration error is caused by
+// a lock timeout or a serialization failure.
+func isRetryable(err error) bool {
+	var pgErr pg.Error
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	switch pgErr.Field('C') {
+	case "55P03", "40001":
+		return true
+	}
+	return false
+}
+
+// runRetry runs the migration with the retry policy. Every attempt
+// is run in a new transaction that locks the migrations table again.
+// version is the version before the migration.
+func (c *Collection) runRetry(
+	ctx context.Context, db DB, tx *pg.Tx, res *RunResult,
+	m *Migration, direction string, version int64, fn func(DB) (int64, error),
+) (*pg.Tx, int64, error) {
+	useTx, isolation := m.UpTx, m.upIsolation
+	if direction == "down" {
+		useTx, isolation = m.DownTx, m.downIsolation
+	}
+
+	var policy *RetryPolicy
+	if useTx {
+		policy = c.migrationRetries[m.Version]
+		if policy == nil {
+			policy = m.Retry
+		}
+		if policy == nil {
+			policy = c.retry
+		}
+	}
+	// Migrations run in one transaction are rolled back as a whole.
+	retry := policy != nil && !c.singleTx(res.Command)
+
+	for attempt := 1; ; attempt++ {
+		mdb := db
+		if useTx {
+			mdb = tx
+		}
+		reset := func() {}
+		if policy != nil && policy.LockTimeout > 0 {
+			var err error
+			reset, err = applySQLSettings(tx, []sqlSetting{{
+				name:  "lock_timeout",
+				value: strconv.FormatInt(policy.LockTimeout.Milliseconds(), 10),
+			}})
+			if err != nil {
+				return tx, version, err
+			}
+		}
+
+		newVersion, err := c.run(db, tx, res, m, direction, version, func() (int64, error) {
+			return fn(mdb)
+		})
+		if err == nil {
+			// The transaction may be used by the next migrations.
+			reset()
+		}
+		if err == nil || !retry || attempt >= policy.MaxAttempts || !isRetryable(err) {
+			return tx, newVersion, err
+		}
+
+		delay := policy.backoff(attempt)
+		c.onRetry(ctx, m, direction, attempt, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return tx, version, ctx.Err()
+		}
+
+		var v int64
+		tx, v, err = c.begin(ctx, db, isolation)
+		if err != nil {
+			return nil, version, err
+		}
+		if v != version {
+			return tx, version, fmt.Errorf(
+				"version was changed from %d to %d by another run", version, v)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := &RetryPolicy{MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	wanted := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, d := range wanted {
+		if got := policy.backoff(i + 1); got != d {
+			t.Errorf("backoff(%d) = %s, wanted %s", i+1, got, d)
+		}
+	}
+
+	policy = new(RetryPolicy)
+	if got := policy.backoff(1); got != 100*time.Millisecond {
+		t.Errorf("got %s, wanted 100ms", got)
+	}
+	if got := policy.backoff(100); got != 10*time.Second {
+		t.Errorf("got %s, wanted 10s", got)
+	}
+}
+
+type pgError struct {
+	code string
+}
+
+func (e pgError) Error() string            { return "ERROR #" + e.code }
+func (e pgError) Field(field byte) string  { return e.code }
+func (e pgError) IntegrityViolation() bool { return false }
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err    error
+		wanted bool
+	}{
+		{pgError{"55P03"}, true},
+		{pgError{"40001"}, true},
+		{pgError{"23505"}, false},
+		{fmt.Errorf("wrapped: %w", pgError{"55P03"}), true},
+		{&MigrationError{Version: 1, Err: pgError{"40001"}}, true},
+		{fmt.Errorf("lock timeout"), false},
+	}
+	for _, test := range tests {
+		if got := isRetryable(test.err); got != test.wanted {
+			t.Errorf("isRetryable(%v) = %t, wanted %t", test.err, got, test.wanted)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package migrations
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// sqlQuery is a query from an SQL migration file.
+type sqlQuery struct {
+	query string
+	// line and column of the query start, starting from 1.
+	line   int
+	column int
+}
+
+// splitSQL splits the SQL into statements on semicolons that are not
+// inside quoted strings, quoted identifiers, dollar-quoted strings
+// or comments. Statements that consist only of comments are skipped.
+// Line numbers are relative to the start of the SQL.
+func splitSQL(sql string) []sqlQuery {
+	var queries []sqlQuery
+
+	line := 1
+	start, startLine := 0, 1
+	hasCode := false
+
+	flush := func(end int) {
+		if hasCode {
+			q := sql[start:end]
+			trimmed := strings.TrimLeft(q, " \t\r\n")
+			offset := start + len(q) - len(trimmed)
+			lineStart := strings.LastIndexByte(sql[:offset], '\n') + 1
+			queries = append(queries, sqlQuery{
+				query:  strings.TrimRight(trimmed, " \t\r\n"),
+				line:   startLine + strings.Count(q[:len(q)-len(trimmed)], "\n"),
+				column: utf8.RuneCountInString(sql[lineStart:offset]) + 1,
+			})
+		}
+		hasCode = false
+	}
+
+	for i := 0; i < len(sql); i++ {
+		ch := sql[i]
+		switch {
+		case ch == '\n':
+			line++
+		case ch == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end == -1 {
+				i = len(sql)
+			} else {
+				i += end - 1
+			}
+		case ch == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := blockCommentEnd(sql, i)
+			line += strings.Count(sql[i:end], "\n")
+			i = end - 1
+		case ch == '\'':
+			escapes := i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e') &&
+				(i == 1 || !isIdentByte(sql[i-2]))
+			end := quoteEnd(sql, i, '\'', escapes)
+			line += strings.Count(sql[i:end], "\n")
+			i = end - 1
+			hasCode = true
+		case ch == '"':
+			end := quoteEnd(sql, i, '"', false)
+			line += strings.Count(sql[i:end], "\n")
+			i = end - 1
+			hasCode = true
+		case ch == '$' && (i == 0 || !isIdentByte(sql[i-1])):
+			end, ok := dollarQuoteEnd(sql, i)
+			if ok {
+				line += strings.Count(sql[i:end], "\n")
+				i = end - 1
+			}
+			hasCode = true
+		case ch == ';':
+			flush(i)
+			start, startLine = i+1, line
+		case ch == ' ' || ch == '\t' || ch == '\r':
+		default:
+			hasCode = true
+		}
+	}
+	flush(len(sql))
+
+	return queries
+}
+
+// quoteEnd returns the index after the closing quote of the string
+// starting at i. Doubled quotes are treated as escaped quotes.
+func quoteEnd(sql string, i int, quote byte, backslashEscapes bool) int {
+	for i++; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			if backslashEscapes {
+				i++
+			}
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+// blockCommentEnd returns the index after the end of the comment starting
+// at i. Block comments in PostgreSQL can be nested.
+func blockCommentEnd(sql string, i int) int {
+	depth := 0
+	for i < len(sql) {
+		switch {
+		case strings.HasPrefix(sql[i:], "/*"):
+			depth++
+			i += 2
+		case strings.HasPrefix(sql[i:], "*/"):
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return len(sql)
+}
+
+// dollarQuoteEnd returns the index after the dollar-quoted string starting
+// at i, e.g. $$body$$ or $fn$body$fn$. It returns false if there is
+// no dollar quote at i, e.g. for positional parameters like $1.
+func dollarQuoteEnd(sql string, i int) (int, bool) {
+	j := i + 1
+	for j < len(sql) && isIdentByte(sql[j]) {
+		if j == i+1 && sql[j] >= '0' && sql[j] <= '9' {
+			return 0, false
+		}
+		j++
+	}
+	if j >= len(sql) || sql[j] != '$' {
+		return 0, false
+	}
+
+	tag := sql[i : j+1]
+	end := strings.Index(sql[j+1:], tag)
+	if end == -1 {
+		return len(sql), true
+	}
+	return j + 1 + end + len(tag), true
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' ||
+		(c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9') ||
+		c >= 0x80
+}
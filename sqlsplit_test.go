@@ -0,0 +1,92 @@
+package migrations
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitSQL(t *testing.T) {
+	tests := []struct {
+		sql     string
+		queries []sqlQuery
+	}{
+		{
+			sql: "SELECT 1; SELECT 2;",
+			queries: []sqlQuery{
+				{query: "SELECT 1", line: 1, column: 1},
+				{query: "SELECT 2", line: 1, column: 11},
+			},
+		},
+		{
+			sql: "SELECT 1;\n\n  SELECT 2\n",
+			queries: []sqlQuery{
+				{query: "SELECT 1", line: 1, column: 1},
+				{query: "SELECT 2", line: 3, column: 3},
+			},
+		},
+		{
+			sql: "SELECT 'a;b', 'it''s;';\nSELECT E'\\';', \"c;\"\"d\"",
+			queries: []sqlQuery{
+				{query: "SELECT 'a;b', 'it''s;'", line: 1, column: 1},
+				{query: "SELECT E'\\';', \"c;\"\"d\"", line: 2, column: 1},
+			},
+		},
+		{
+			sql: "-- comment; here\nSELECT 1; /* block; /* nested; */ */ SELECT 2;\n-- trailing;",
+			queries: []sqlQuery{
+				{query: "-- comment; here\nSELECT 1", line: 1, column: 1},
+				{query: "/* block; /* nested; */ */ SELECT 2", line: 2, column: 11},
+			},
+		},
+		{
+			sql: "CREATE FUNCTION f() RETURNS int AS $$\nBEGIN\n  RETURN 1;\nEND;\n$$ LANGUAGE plpgsql;\nSELECT $fn$;$fn$;",
+			queries: []sqlQuery{
+				{query: "CREATE FUNCTION f() RETURNS int AS $$\nBEGIN\n  RETURN 1;\nEND;\n$$ LANGUAGE plpgsql", line: 1, column: 1},
+				{query: "SELECT $fn$;$fn$", line: 6, column: 1},
+			},
+		},
+		{
+			sql: "PREPARE q AS SELECT $1; EXECUTE q(1)",
+			queries: []sqlQuery{
+				{query: "PREPARE q AS SELECT $1", line: 1, column: 1},
+				{query: "EXECUTE q(1)", line: 1, column: 25},
+			},
+		},
+		{
+			sql: "  ;\n-- only comments;\n",
+		},
+	}
+
+	for _, test := range tests {
+		got := splitSQL(test.sql)
+		if !reflect.DeepEqual(got, test.queries) {
+			t.Errorf("splitSQL(%q) = %+v, wanted %+v", test.sql, got, test.queries)
+		}
+	}
+}
+
+func TestReadSQLMigrationSplit(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "1_initial.up.sql")
+	sql := "SET lock_timeout = 1000;\n--gopg:split\nSELECT 1;\n\nSELECT 2;\n"
+	if err := ioutil.WriteFile(file, []byte(sql), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	coll := NewCollection().SplitSQLStatements(true)
+	queries, err := coll.readSQLMigration(osfilesystem{}, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wanted := []sqlQuery{
+		{query: "SET lock_timeout = 1000", line: 1, column: 1},
+		{query: "SELECT 1", line: 3, column: 1},
+		{query: "SELECT 2", line: 5, column: 1},
+	}
+	if !reflect.DeepEqual(queries, wanted) {
+		t.Fatalf("got %+v, wanted %+v", queries, wanted)
+	}
+}
@@ -0,0 +1,118 @@
+package migrations
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// MigrationState is the state of a migration reported by Status.
+type MigrationState string
+
+const (
+	// StateApplied means that the migration is applied.
+	StateApplied MigrationState = "applied"
+	// StatePending means that the migration will be applied by up.
+	StatePending MigrationState = "pending"
+	// StateMissing means that the migration is applied,
+	// but it is not known to the collection.
+	StateMissing MigrationState = "missing"
+	// StateIgnored means that the migration is not applied and up
+	// skips it because a migration with a higher version is applied.
+	// See AllowOutOfOrder.
+	StateIgnored MigrationState = "ignored"
+)
+
+// MigrationStatus describes a migration reported by Status.
+type MigrationStatus struct {
+	Version     int64
+	Name        string
+	Description string
+	State       MigrationState
+	// AppliedAt is zero when the migration is not applied.
+	AppliedAt time.Time
+	// File is the Go or SQL file the migration was loaded from.
+	File string
+}
+
+// Status returns every migration known to the collection or recorded
+// in the migrations table along with its state, sorted by version.
+func (c *Collection) Status(db DB) ([]*MigrationStatus, error) {
+	migrations, err := c.discoverMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := validateMigrations(migrations); err != nil {
+		return nil, err
+	}
+
+	version, err := c.Version(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.status(db, migrations, version)
+}
+
+func (c *Collection) status(
+	db DB, migrations []*Migration, version int64,
+) ([]*MigrationStatus, error) {
+	applied, err := c.appliedVersions(db, migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]*MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		st := &MigrationStatus{
+			Version:     m.Version,
+			Name:        m.Name,
+			Description: m.Description,
+			File:        m.upFile,
+		}
+
+		if am := applied[m.Version]; am != nil {
+			st.State = StateApplied
+			st.AppliedAt = am.appliedAt
+			delete(applied, m.Version)
+		} else if m.Version < version && !c.outOfOrder {
+			st.State = StateIgnored
+		} else {
+			st.State = StatePending
+		}
+
+		status = append(status, st)
+	}
+
+	for v, am := range applied {
+		status = append(status, &MigrationStatus{
+			Version:     v,
+			Name:        am.name,
+			Description: am.description,
+			State:       StateMissing,
+			AppliedAt:   am.appliedAt,
+		})
+	}
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Version < status[j].Version
+	})
+
+	return status, nil
+}
+
+func writeStatus(w io.Writer, status []*MigrationStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATE\tAPPLIED AT\tFILE")
+	for _, st := range status {
+		var appliedAt string
+		if !st.AppliedAt.IsZero() {
+			appliedAt = st.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n",
+			st.Version, st.Name, st.State, appliedAt, st.File)
+	}
+	return tw.Flush()
+}
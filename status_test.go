@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteStatus(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeStatus(&buf, []*MigrationStatus{
+		{
+			Version:   1,
+			Name:      "initial",
+			State:     StateApplied,
+			AppliedAt: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC),
+			File:      "1_initial.up.sql",
+		},
+		{Version: 2, Name: "add_id", State: StatePending, File: "2_add_id.go"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, wanted 3", len(lines))
+	}
+	if fields := strings.Fields(lines[1]); len(fields) != 5 || fields[3] != "2021-03-01T12:00:00Z" {
+		t.Fatalf("unexpected line: %q", lines[1])
+	}
+	if fields := strings.Fields(lines[2]); len(fields) != 4 || fields[2] != "pending" {
+		t.Fatalf("unexpected line: %q", lines[2])
+	}
+}
@@ -0,0 +1,80 @@
+package migrations
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// steps describes migrations applied or reverted by the up, down,
+// down-to, reset and goto commands.
+type steps struct {
+	direction string
+	// target is the highest version to apply or the version to revert to.
+	target int64
+	// n limits the number of migrations. Zero means no limit.
+	n int
+}
+
+func parseSteps(cmd Command, version int64) (*steps, error) {
+	switch cmd.Name {
+	case "up":
+		s := &steps{direction: "up", target: math.MaxInt64}
+		if len(cmd.Args) == 0 {
+			return s, nil
+		}
+		if arg := cmd.Args[0]; strings.HasPrefix(arg, "+") {
+			n, err := parseCount(arg[1:])
+			if err != nil {
+				return nil, err
+			}
+			s.n = n
+			return s, nil
+		}
+		target, err := strconv.ParseInt(cmd.Args[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		s.target = target
+		return s, nil
+	case "down":
+		s := &steps{direction: "down", n: 1}
+		if len(cmd.Args) > 0 {
+			n, err := parseCount(cmd.Args[0])
+			if err != nil {
+				return nil, err
+			}
+			s.n = n
+		}
+		return s, nil
+	case "reset":
+		return &steps{direction: "down"}, nil
+	case "down-to", "goto":
+		if len(cmd.Args) == 0 {
+			return nil, fmt.Errorf(
+				"%s requires version as 2nd arg, e.g. %s 42", cmd.Name, cmd.Name)
+		}
+		target, err := strconv.ParseInt(cmd.Args[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if cmd.Name == "goto" && target >= version {
+			return &steps{direction: "up", target: target}, nil
+		}
+		return &steps{direction: "down", target: target}, nil
+	default:
+		return nil, fmt.Errorf("unsupported command: %q", cmd.Name)
+	}
+}
+
+func parseCount(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("number of migrations must be positive, got %d", n)
+	}
+	return n, nil
+}
@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseSteps(t *testing.T) {
+	tests := []struct {
+		args   []string
+		wanted *steps
+	}{
+		{nil, &steps{direction: "up", target: math.MaxInt64}},
+		{[]string{"up", "7"}, &steps{direction: "up", target: 7}},
+		{[]string{"up", "+2"}, &steps{direction: "up", target: math.MaxInt64, n: 2}},
+		{[]string{"down"}, &steps{direction: "down", n: 1}},
+		{[]string{"down", "3"}, &steps{direction: "down", n: 3}},
+		{[]string{"down-to", "2"}, &steps{direction: "down", target: 2}},
+		{[]string{"reset"}, &steps{direction: "down"}},
+		{[]string{"goto", "2"}, &steps{direction: "down", target: 2}},
+		{[]string{"goto", "5"}, &steps{direction: "up", target: 5}},
+		{[]string{"goto", "9"}, &steps{direction: "up", target: 9}},
+	}
+	for _, test := range tests {
+		got, err := parseSteps(ParseCommand(test.args...), 5)
+		if err != nil {
+			t.Errorf("parseSteps(%q): %s", test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.wanted) {
+			t.Errorf("parseSteps(%q) = %+v, wanted %+v", test.args, got, test.wanted)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"up", "+0"},
+		{"up", "+x"},
+		{"down", "-1"},
+		{"down-to"},
+		{"goto", "x"},
+		{"sideways"},
+	} {
+		if _, err := parseSteps(ParseCommand(args...), 5); err == nil {
+			t.Errorf("parseSteps(%q) succeeded, wanted an error", args)
+		}
+	}
+}